@@ -0,0 +1,59 @@
+package badger
+
+import (
+	"context"
+	"io"
+)
+
+// Backup streams every entry newer than or equal to since into w using
+// badger's backup format. It returns the version of the last dumped entry,
+// which after incrementing by one can be passed as since to produce an
+// incremental backup.
+func (db *BadgerApp) Backup(ctx context.Context, w io.Writer, since uint64) (uint64, error) {
+	stream := db.DB.NewStream()
+	stream.LogPrefix = "BadgerApp.Backup"
+	stream.SinceTs = since
+	return stream.Backup(&contextWriter{ctx, w}, since)
+}
+
+// Restore loads a backup produced by Backup into the store. It should not run
+// concurrently with other writes. Like Load, it fails with a *QuotaError
+// while a hard limit of the quota is exceeded.
+func (db *BadgerApp) Restore(ctx context.Context, r io.Reader) error {
+	return db.Load(&contextReader{ctx, r}, 256)
+}
+
+// Load loads a backup like badger.DB.Load, unless a hard limit of the quota
+// is exceeded when it starts.
+func (db *BadgerApp) Load(r io.Reader, maxPendingWrites int) error {
+	if err := db.checkQuota(); err != nil {
+		return err
+	}
+	return db.DB.Load(r, maxPendingWrites)
+}
+
+// contextWriter aborts writes once its context is done, which in turn stops
+// the stream feeding it.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w *contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,257 @@
+package badger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const backupManifestFile = "manifest.json"
+
+type backupConfig struct {
+	Dir            string        `mapstructure:"dir"`
+	Interval       time.Duration `mapstructure:"interval"`
+	FullEvery      int           `mapstructure:"full_every"`
+	RetentionCount int           `mapstructure:"retention_count"`
+	RetentionAge   time.Duration `mapstructure:"retention_age"`
+}
+
+// backupManifest records the backups kept in a backup directory. Backups form
+// chains, each starting with a full backup followed by incremental ones.
+type backupManifest struct {
+	Since   uint64        `json:"since"`
+	Backups []backupEntry `json:"backups"`
+}
+
+type backupEntry struct {
+	File      string    `json:"file"`
+	Since     uint64    `json:"since"`
+	Version   uint64    `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (e backupEntry) full() bool {
+	return e.Since == 0
+}
+
+func readBackupManifest(dir string) (*backupManifest, error) {
+	content, err := os.ReadFile(filepath.Join(dir, backupManifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return &backupManifest{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var m backupManifest
+	if err := json.Unmarshal(content, &m); err != nil {
+		return nil, fmt.Errorf("parse backup manifest: %w", err)
+	}
+	return &m, nil
+}
+
+func (m *backupManifest) write(dir string) error {
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, backupManifestFile), func(f *os.File) error {
+		_, err := f.Write(content)
+		return err
+	})
+}
+
+// BackupToDir writes a backup into dir and records it in the directory
+// manifest. The backup is incremental on top of the last one recorded unless
+// full is set or the manifest is empty.
+func (db *BadgerApp) BackupToDir(ctx context.Context, dir string, full bool) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	m, err := readBackupManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	since := m.Since
+	if full || len(m.Backups) == 0 {
+		since = 0
+	}
+
+	now := time.Now().UTC()
+	entry := backupEntry{
+		File:      fmt.Sprintf("backup-%s-%d.bak", now.Format("20060102T150405.000000000Z"), since),
+		Since:     since,
+		CreatedAt: now,
+	}
+
+	err = writeFileAtomic(filepath.Join(dir, entry.File), func(f *os.File) error {
+		entry.Version, err = db.Backup(ctx, f, since)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	m.Backups = append(m.Backups, entry)
+	if entry.Version > 0 {
+		m.Since = entry.Version + 1
+	}
+	if err := m.write(dir); err != nil {
+		return err
+	}
+
+	db.Logger.Info("backup finished",
+		zap.String("file", entry.File),
+		zap.Uint64("since", entry.Since),
+		zap.Uint64("version", entry.Version))
+	return nil
+}
+
+// RestoreFromDir restores the latest backup chain recorded in dir.
+func (db *BadgerApp) RestoreFromDir(ctx context.Context, dir string) error {
+	m, err := readBackupManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	start := -1
+	for i, entry := range m.Backups {
+		if entry.full() {
+			start = i
+		}
+	}
+	if start < 0 {
+		return fmt.Errorf("no full backup found in %s", dir)
+	}
+
+	for _, entry := range m.Backups[start:] {
+		if err := db.restoreFile(ctx, filepath.Join(dir, entry.File)); err != nil {
+			return fmt.Errorf("restore %s: %w", entry.File, err)
+		}
+	}
+	return nil
+}
+
+func (db *BadgerApp) restoreFile(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return db.Restore(ctx, f)
+}
+
+// pruneBackups removes backups outside the configured retention. A backup is
+// only removed together with the whole chain it belongs to, so every kept
+// backup can still be restored.
+func (db *BadgerApp) pruneBackups(dir string) error {
+	conf := db.config.Backup
+	m, err := readBackupManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	keepFrom := 0
+	if conf.RetentionCount > 0 && len(m.Backups) > conf.RetentionCount {
+		keepFrom = len(m.Backups) - conf.RetentionCount
+	}
+	if conf.RetentionAge > 0 {
+		deadline := time.Now().Add(-conf.RetentionAge)
+		for keepFrom < len(m.Backups)-1 && m.Backups[keepFrom].CreatedAt.Before(deadline) {
+			keepFrom++
+		}
+	}
+	for keepFrom > 0 && !m.Backups[keepFrom].full() {
+		keepFrom--
+	}
+	if keepFrom == 0 {
+		return nil
+	}
+
+	removed := m.Backups[:keepFrom]
+	m.Backups = m.Backups[keepFrom:]
+	if err := m.write(dir); err != nil {
+		return err
+	}
+
+	for _, entry := range removed {
+		if err := os.Remove(filepath.Join(dir, entry.File)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		db.Logger.Info("backup pruned", zap.String("file", entry.File))
+	}
+	return nil
+}
+
+func (db *BadgerApp) runBackup(ctx context.Context) {
+	conf := db.config.Backup
+	ticker := time.NewTicker(conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		m, err := readBackupManifest(conf.Dir)
+		if err != nil {
+			db.Logger.Error("read backup manifest failed", zap.Error(err))
+			continue
+		}
+
+		full := conf.FullEvery > 0 && chainLength(m) >= conf.FullEvery
+		if err := db.BackupToDir(ctx, conf.Dir, full); err != nil {
+			if ctx.Err() == nil {
+				db.Logger.Error("backup failed", zap.Error(err))
+			}
+			continue
+		}
+		if err := db.pruneBackups(conf.Dir); err != nil {
+			db.Logger.Error("prune backups failed", zap.Error(err))
+		}
+	}
+}
+
+// chainLength returns the number of backups in the latest chain.
+func chainLength(m *backupManifest) int {
+	for i := len(m.Backups) - 1; i >= 0; i-- {
+		if m.Backups[i].full() {
+			return len(m.Backups) - i
+		}
+	}
+	return 0
+}
+
+// writeFileAtomic writes path through a temporary file that is renamed into
+// place once fn succeeds.
+func writeFileAtomic(path string, fn func(*os.File) error) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
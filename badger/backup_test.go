@@ -0,0 +1,90 @@
+package badger
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func set(app *BadgerApp, key, value string) error {
+	return app.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(key), []byte(value))
+	})
+}
+
+func get(app *BadgerApp, key string) (string, error) {
+	var value []byte
+	err := app.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		value, err = item.ValueCopy(nil)
+		return err
+	})
+	return string(value), err
+}
+
+func TestBackup(t *testing.T) {
+	Convey("Backup and restore", t, func() {
+		src, dst := newMemoryApp(t), newMemoryApp(t)
+		So(set(src, "a", "1"), ShouldBeNil)
+
+		var buf bytes.Buffer
+		version, err := src.Backup(t.Context(), &buf, 0)
+		So(err, ShouldBeNil)
+		So(version, ShouldBeGreaterThan, 0)
+
+		So(dst.Restore(t.Context(), &buf), ShouldBeNil)
+		value, err := get(dst, "a")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, "1")
+	})
+
+	Convey("Backup directory", t, func() {
+		dir := t.TempDir()
+		src, dst := newMemoryApp(t), newMemoryApp(t)
+		src.config.Backup.RetentionCount = 2
+
+		So(set(src, "a", "1"), ShouldBeNil)
+		So(src.BackupToDir(t.Context(), dir, false), ShouldBeNil)
+		So(set(src, "b", "2"), ShouldBeNil)
+		So(src.BackupToDir(t.Context(), dir, false), ShouldBeNil)
+		So(set(src, "c", "3"), ShouldBeNil)
+		So(src.BackupToDir(t.Context(), dir, false), ShouldBeNil)
+
+		m, err := readBackupManifest(dir)
+		So(err, ShouldBeNil)
+		So(m.Backups, ShouldHaveLength, 3)
+		So(m.Backups[0].full(), ShouldBeTrue)
+		So(m.Backups[1].full(), ShouldBeFalse)
+
+		// the incremental backups depend on the first one, so nothing is pruned
+		So(src.pruneBackups(dir), ShouldBeNil)
+		m, _ = readBackupManifest(dir)
+		So(m.Backups, ShouldHaveLength, 3)
+
+		chain := newMemoryApp(t)
+		So(chain.RestoreFromDir(t.Context(), dir), ShouldBeNil)
+		value, err := get(chain, "c")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, "3")
+
+		So(src.BackupToDir(t.Context(), dir, true), ShouldBeNil)
+		src.config.Backup.RetentionCount = 1
+		src.config.Backup.RetentionAge = time.Hour
+		So(src.pruneBackups(dir), ShouldBeNil)
+		m, _ = readBackupManifest(dir)
+		So(m.Backups, ShouldHaveLength, 1)
+
+		So(dst.RestoreFromDir(t.Context(), dir), ShouldBeNil)
+		for key, expected := range map[string]string{"a": "1", "b": "2", "c": "3"} {
+			value, err := get(dst, key)
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, expected)
+		}
+	})
+}
@@ -0,0 +1,263 @@
+package badger
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"go.uber.org/zap"
+)
+
+// ErrBatchWriterClosed is returned when writing to a closed BatchWriter.
+var ErrBatchWriterClosed = errors.New("batch writer is closed")
+
+type batchOptions struct {
+	flushSize       int64
+	flushInterval   time.Duration
+	maxPendingBytes int64
+	onError         func(key []byte, err error)
+}
+
+type BatchOption func(*batchOptions)
+
+// WithFlushSize flushes the buffered entries once they reach size bytes. The
+// default is 4 MiB.
+func WithFlushSize(size int64) BatchOption {
+	return func(o *batchOptions) {
+		o.flushSize = size
+	}
+}
+
+// WithFlushInterval flushes the buffered entries at least every interval.
+// The default is one second.
+func WithFlushInterval(interval time.Duration) BatchOption {
+	return func(o *batchOptions) {
+		o.flushInterval = interval
+	}
+}
+
+// WithMaxPendingBytes blocks writers while more than size bytes are buffered
+// or being flushed. The default is 64 MiB.
+func WithMaxPendingBytes(size int64) BatchOption {
+	return func(o *batchOptions) {
+		o.maxPendingBytes = size
+	}
+}
+
+// WithErrorHandler sets the function called with every entry that failed to
+// be written. The default logs a warning.
+func WithErrorHandler(fn func(key []byte, err error)) BatchOption {
+	return func(o *batchOptions) {
+		o.onError = fn
+	}
+}
+
+type batchEntry struct {
+	entry  *badger.Entry
+	delete bool
+}
+
+// BatchWriter buffers writes and commits them in large batches through a
+// badger.WriteBatch, trading atomicity for throughput: every entry is written
+// on its own and failures are reported per entry. badger's StreamWriter is
+// faster still but only fills empty stores from sorted keys, see Restore.
+//
+// The buffer is flushed in the background, on Close and when the app closes.
+type BatchWriter struct {
+	db      *BadgerApp
+	options batchOptions
+
+	mu            sync.Mutex
+	pending       []batchEntry
+	bufferedBytes int64
+	pendingBytes  int64
+	drained       chan struct{}
+	closed        bool
+
+	flushMu sync.Mutex
+	kick    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewBatchWriter starts a batch writer, it has to be created after Initialize.
+func (db *BadgerApp) NewBatchWriter(opts ...BatchOption) *BatchWriter {
+	w := &BatchWriter{
+		db: db,
+		options: batchOptions{
+			flushSize:       4 << 20,
+			flushInterval:   time.Second,
+			maxPendingBytes: 64 << 20,
+		},
+		drained: make(chan struct{}),
+		kick:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&w.options)
+	}
+	if w.options.onError == nil {
+		w.options.onError = func(key []byte, err error) {
+			db.Logger.Warn("batch write failed", zap.String("key", printableKey(key)), zap.Error(err))
+		}
+	}
+
+	db.goBackground(w.run)
+	return w
+}
+
+// Set buffers a write of value under key. It blocks while the writer holds
+// more than the maximum pending bytes. key and value must not be modified
+// afterwards.
+func (w *BatchWriter) Set(ctx context.Context, key, value []byte) error {
+	return w.add(ctx, batchEntry{entry: badger.NewEntry(key, value)})
+}
+
+// SetEntry is like Set for an entry carrying a TTL or user meta.
+func (w *BatchWriter) SetEntry(ctx context.Context, e *badger.Entry) error {
+	return w.add(ctx, batchEntry{entry: e})
+}
+
+// Delete buffers the deletion of key.
+func (w *BatchWriter) Delete(ctx context.Context, key []byte) error {
+	return w.add(ctx, batchEntry{entry: badger.NewEntry(key, nil), delete: true})
+}
+
+// Flush writes the buffered entries and returns the error of the commit, if
+// any. Failed entries are also reported to the error handler.
+func (w *BatchWriter) Flush() error {
+	return w.flush()
+}
+
+// Close flushes the buffered entries and stops the writer.
+func (w *BatchWriter) Close() error {
+	w.mu.Lock()
+	closed := w.closed
+	w.closed = true
+	w.mu.Unlock()
+	if !closed {
+		close(w.done)
+	}
+	<-w.stopped
+	return w.flush()
+}
+
+func (w *BatchWriter) add(ctx context.Context, e batchEntry) error {
+	if !e.delete {
+		if err := w.db.checkQuota(); err != nil {
+			return err
+		}
+	}
+	size := int64(len(e.entry.Key) + len(e.entry.Value))
+
+	w.mu.Lock()
+	for !w.closed && w.pendingBytes > 0 && w.pendingBytes+size > w.options.maxPendingBytes {
+		drained := w.drained
+		w.mu.Unlock()
+		w.signal()
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		w.mu.Lock()
+	}
+	if w.closed {
+		w.mu.Unlock()
+		return ErrBatchWriterClosed
+	}
+	w.pending = append(w.pending, e)
+	w.bufferedBytes += size
+	w.pendingBytes += size
+	full := w.bufferedBytes >= w.options.flushSize
+	w.mu.Unlock()
+
+	if full {
+		w.signal()
+	}
+	return nil
+}
+
+func (w *BatchWriter) signal() {
+	select {
+	case w.kick <- struct{}{}:
+	default:
+	}
+}
+
+// run flushes the buffer when it fills up and every flush interval until the
+// writer or the app is closed.
+func (w *BatchWriter) run(ctx context.Context) {
+	defer close(w.stopped)
+
+	ticker := time.NewTicker(w.options.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			w.mu.Lock()
+			w.closed = true
+			w.mu.Unlock()
+			_ = w.flush()
+			return
+		case <-w.done:
+			return
+		case <-ticker.C:
+		case <-w.kick:
+		}
+		_ = w.flush()
+	}
+}
+
+func (w *BatchWriter) flush() error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	entries, size := w.pending, w.bufferedBytes
+	w.pending, w.bufferedBytes = nil, 0
+	w.mu.Unlock()
+	if len(entries) == 0 {
+		return nil
+	}
+
+	err := w.write(entries)
+
+	w.mu.Lock()
+	w.pendingBytes -= size
+	close(w.drained)
+	w.drained = make(chan struct{})
+	w.mu.Unlock()
+	return err
+}
+
+func (w *BatchWriter) write(entries []batchEntry) error {
+	wb := w.db.DB.NewWriteBatch()
+	defer wb.Cancel()
+
+	written := make([][]byte, 0, len(entries))
+	for _, e := range entries {
+		var err error
+		if e.delete {
+			err = wb.Delete(e.entry.Key)
+		} else {
+			err = wb.SetEntry(e.entry)
+		}
+		if err != nil {
+			w.options.onError(e.entry.Key, err)
+			continue
+		}
+		written = append(written, e.entry.Key)
+	}
+
+	if err := wb.Flush(); err != nil {
+		for _, key := range written {
+			w.options.onError(key, err)
+		}
+		return err
+	}
+	return nil
+}
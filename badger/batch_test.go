@@ -0,0 +1,91 @@
+package badger
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBatchWriter(t *testing.T) {
+	app := newMemoryApp(t)
+	ctx := t.Context()
+
+	Convey("BatchWriter", t, func() {
+		Convey("flushes everything on Close", func() {
+			w := app.NewBatchWriter(WithFlushSize(1 << 10))
+			for i := range 1000 {
+				So(w.Set(ctx, fmt.Appendf(nil, "batch/%04d", i), []byte("value")), ShouldBeNil)
+			}
+			So(w.Delete(ctx, []byte("batch/0000")), ShouldBeNil)
+			So(w.Close(), ShouldBeNil)
+			So(w.Set(ctx, []byte("batch/late"), nil), ShouldEqual, ErrBatchWriterClosed)
+
+			_, err := get(app, "batch/0000")
+			So(err, ShouldEqual, badger.ErrKeyNotFound)
+			value, err := get(app, "batch/0999")
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, "value")
+		})
+
+		Convey("flushes every interval", func() {
+			w := app.NewBatchWriter(WithFlushInterval(10 * time.Millisecond))
+			defer w.Close()
+			So(w.Set(ctx, []byte("interval"), []byte("value")), ShouldBeNil)
+			So(func() bool {
+				for range 100 {
+					if _, err := get(app, "interval"); err == nil {
+						return true
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+				return false
+			}(), ShouldBeTrue)
+		})
+
+		Convey("blocks writers above the pending limit", func() {
+			w := app.NewBatchWriter(WithMaxPendingBytes(10))
+			defer w.Close()
+			So(w.Set(ctx, []byte("pending/1"), []byte("v")), ShouldBeNil)
+
+			// a flush in progress keeps the pending bytes up
+			w.flushMu.Lock()
+			timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+			defer cancel()
+			So(w.Set(timeout, []byte("pending/2"), []byte("v")), ShouldEqual, context.DeadlineExceeded)
+			w.flushMu.Unlock()
+
+			So(w.Set(ctx, []byte("pending/2"), []byte("v")), ShouldBeNil)
+		})
+
+		Convey("reports failed entries", func() {
+			var failed []string
+			w := app.NewBatchWriter(WithErrorHandler(func(key []byte, err error) {
+				failed = append(failed, string(key))
+			}))
+			So(w.Set(ctx, []byte("ok"), []byte("v")), ShouldBeNil)
+			So(w.Set(ctx, []byte("too-big"), make([]byte, 2<<20)), ShouldBeNil)
+			So(w.Close(), ShouldBeNil)
+			So(failed, ShouldResemble, []string{"too-big"})
+		})
+	})
+
+	Convey("BatchWriter is flushed when the app closes", t, func() {
+		dir := filepath.Join(t.TempDir(), "db")
+		app := newDiskApp(t, dir)
+		w := app.NewBatchWriter(WithFlushInterval(time.Hour))
+		So(w.Set(ctx, []byte("key"), []byte("value")), ShouldBeNil)
+		app.Close(ctx)
+		So(w.Close(), ShouldBeNil)
+
+		app = newDiskApp(t, dir)
+		defer app.Close(ctx)
+		value, err := get(app, "key")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, "value")
+	})
+}
@@ -0,0 +1,201 @@
+package badger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"golang.org/x/sync/singleflight"
+)
+
+// CachedError is returned by a Cache when the error of an earlier load is
+// served from the negative cache.
+type CachedError struct {
+	Message string
+}
+
+func (e *CachedError) Error() string {
+	return e.Message
+}
+
+// CacheStats holds the counters of a Cache.
+type CacheStats struct {
+	Hits       uint64
+	Misses     uint64
+	Loads      uint64
+	LoadErrors uint64
+}
+
+type cacheOptions struct {
+	ttl      time.Duration
+	errorTTL time.Duration
+}
+
+type CacheOption func(*cacheOptions)
+
+// WithCacheTTL sets the default TTL of loaded values, 0 keeps them forever.
+func WithCacheTTL(ttl time.Duration) CacheOption {
+	return func(o *cacheOptions) {
+		o.ttl = ttl
+	}
+}
+
+// WithErrorTTL enables negative caching: load errors are cached for ttl and
+// returned as *CachedError until they expire. Cancellations and deadlines
+// passed are never cached.
+func WithErrorTTL(ttl time.Duration) CacheOption {
+	return func(o *cacheOptions) {
+		o.errorTTL = ttl
+	}
+}
+
+// Cache is a read-through cache persisted in badger. Concurrent loads of the
+// same key are collapsed into a single call of the loader.
+type Cache[K, V any] struct {
+	store   *Store[K, cacheItem[V]]
+	options cacheOptions
+	group   singleflight.Group
+
+	hits, misses, loads, loadErrors atomic.Uint64
+}
+
+// NewCache creates a cache that keeps its entries under namespace in db.
+func NewCache[K, V any](db Transactor, namespace string, keys KeyEncoder[K], codec Codec[V], opts ...CacheOption) *Cache[K, V] {
+	c := &Cache[K, V]{
+		store: NewStore[K, cacheItem[V]](db, namespace, keys, cacheCodec[V]{codec}),
+	}
+	for _, opt := range opts {
+		opt(&c.options)
+	}
+	return c
+}
+
+// GetOrLoad returns the cached value of key, calling load on a miss and
+// caching its result with the default TTL. The load is shared by the
+// concurrent callers of key, so it runs detached from the cancellation of
+// ctx, keeping its values; a caller whose ctx is done stops waiting for it.
+func (c *Cache[K, V]) GetOrLoad(ctx context.Context, key K, load func(context.Context) (V, error)) (V, error) {
+	return c.GetOrLoadWithTTL(ctx, key, c.options.ttl, load)
+}
+
+// GetOrLoadWithTTL is like GetOrLoad but caches a loaded value for ttl.
+func (c *Cache[K, V]) GetOrLoadWithTTL(ctx context.Context, key K, ttl time.Duration, load func(context.Context) (V, error)) (V, error) {
+	var zero V
+
+	item, err := c.store.Get(key)
+	if err == nil {
+		c.hits.Add(1)
+		return item.result()
+	} else if !errors.Is(err, badger.ErrKeyNotFound) {
+		return zero, err
+	}
+	c.misses.Add(1)
+
+	k, err := c.store.keys.EncodeKey(key)
+	if err != nil {
+		return zero, err
+	}
+
+	ch := c.group.DoChan(string(k), func() (any, error) {
+		c.loads.Add(1)
+		value, err := load(context.WithoutCancel(ctx))
+		if err != nil {
+			c.loadErrors.Add(1)
+			if c.options.errorTTL > 0 && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+				if err := c.store.PutWithTTL(key, cacheItem[V]{failed: true, err: err.Error()}, c.options.errorTTL); err != nil {
+					return nil, err
+				}
+			}
+			return nil, err
+		}
+		if err := c.store.PutWithTTL(key, cacheItem[V]{value: value}, ttl); err != nil {
+			return nil, err
+		}
+		return value, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return zero, res.Err
+		}
+		return res.Val.(V), nil
+	}
+}
+
+// Set stores value under key for ttl, 0 keeps it forever.
+func (c *Cache[K, V]) Set(key K, value V, ttl time.Duration) error {
+	return c.store.PutWithTTL(key, cacheItem[V]{value: value}, ttl)
+}
+
+// Invalidate drops the cached value or error of key.
+func (c *Cache[K, V]) Invalidate(key K) error {
+	return c.store.Delete(key)
+}
+
+// Stats returns a snapshot of the cache counters.
+func (c *Cache[K, V]) Stats() CacheStats {
+	return CacheStats{
+		Hits:       c.hits.Load(),
+		Misses:     c.misses.Load(),
+		Loads:      c.loads.Load(),
+		LoadErrors: c.loadErrors.Load(),
+	}
+}
+
+// cacheItem is either a cached value or the message of a cached error.
+type cacheItem[V any] struct {
+	value  V
+	failed bool
+	err    string
+}
+
+func (i cacheItem[V]) result() (V, error) {
+	if i.failed {
+		var zero V
+		return zero, &CachedError{i.err}
+	}
+	return i.value, nil
+}
+
+const (
+	cacheItemValue byte = iota
+	cacheItemError
+)
+
+// cacheCodec prefixes the encoded value with a tag telling values and cached
+// errors apart.
+type cacheCodec[V any] struct {
+	codec Codec[V]
+}
+
+func (c cacheCodec[V]) Marshal(i cacheItem[V]) ([]byte, error) {
+	if i.failed {
+		return append([]byte{cacheItemError}, i.err...), nil
+	}
+	b, err := c.codec.Marshal(i.value)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{cacheItemValue}, b...), nil
+}
+
+func (c cacheCodec[V]) Unmarshal(b []byte) (i cacheItem[V], err error) {
+	if len(b) == 0 {
+		return i, fmt.Errorf("empty cache item")
+	}
+	switch b[0] {
+	case cacheItemValue:
+		i.value, err = c.codec.Unmarshal(b[1:])
+	case cacheItemError:
+		i.failed, i.err = true, string(b[1:])
+	default:
+		err = fmt.Errorf("unknown cache item tag %d", b[0])
+	}
+	return i, err
+}
@@ -0,0 +1,112 @@
+package badger
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCache(t *testing.T) {
+	app := newMemoryApp(t)
+
+	Convey("Cache", t, func() {
+		cache := NewCache(app.DB, "cache", StringKey{}, JSONCodec[int]{}, WithCacheTTL(time.Minute))
+
+		var calls atomic.Int32
+		release := make(chan struct{})
+		load := func(context.Context) (int, error) {
+			calls.Add(1)
+			<-release
+			return 42, nil
+		}
+
+		var wg sync.WaitGroup
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				value, err := cache.GetOrLoad(t.Context(), "answer", load)
+				if err != nil || value != 42 {
+					t.Errorf("unexpected result %d, %v", value, err)
+				}
+			}()
+		}
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		So(calls.Load(), ShouldEqual, 1)
+
+		value, err := cache.GetOrLoad(t.Context(), "answer", load)
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, 42)
+		So(cache.Stats().Hits, ShouldEqual, 1)
+		So(cache.Stats().Loads, ShouldEqual, 1)
+	})
+
+	Convey("Negative cache", t, func() {
+		cache := NewCache(app.DB, "negative", StringKey{}, JSONCodec[int]{}, WithErrorTTL(time.Minute))
+
+		failure := errors.New("quota exceeded")
+		load := func(context.Context) (int, error) { return 0, failure }
+
+		_, err := cache.GetOrLoad(t.Context(), "key", load)
+		So(err, ShouldEqual, failure)
+
+		_, err = cache.GetOrLoad(t.Context(), "key", load)
+		var cached *CachedError
+		So(errors.As(err, &cached), ShouldBeTrue)
+		So(cached.Message, ShouldEqual, "quota exceeded")
+		So(cache.Stats().LoadErrors, ShouldEqual, 1)
+
+		So(cache.Invalidate("key"), ShouldBeNil)
+		So(cache.Set("key", 1, 0), ShouldBeNil)
+		value, err := cache.GetOrLoad(t.Context(), "key", load)
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, 1)
+
+		// timeouts are not cached
+		_, err = cache.GetOrLoad(t.Context(), "slow", func(context.Context) (int, error) {
+			return 0, context.DeadlineExceeded
+		})
+		So(err, ShouldEqual, context.DeadlineExceeded)
+		value, err = cache.GetOrLoad(t.Context(), "slow", func(context.Context) (int, error) { return 2, nil })
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, 2)
+	})
+
+	Convey("A cancelled caller does not fail the shared load", t, func() {
+		cache := NewCache(app.DB, "cancel", StringKey{}, JSONCodec[int]{}, WithErrorTTL(time.Minute))
+
+		release := make(chan struct{})
+		load := func(ctx context.Context) (int, error) {
+			select {
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			case <-release:
+				return 7, nil
+			}
+		}
+
+		ctx, cancel := context.WithCancel(t.Context())
+		done := make(chan error, 1)
+		go func() {
+			_, err := cache.GetOrLoad(ctx, "key", load)
+			done <- err
+		}()
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+		So(<-done, ShouldEqual, context.Canceled)
+
+		close(release)
+		So(waitFor(time.Second, func() bool {
+			value, err := cache.GetOrLoad(t.Context(), "key", load)
+			return err == nil && value == 7
+		}), ShouldBeTrue)
+		So(cache.Stats().Loads, ShouldEqual, 1)
+	})
+}
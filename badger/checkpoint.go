@@ -0,0 +1,117 @@
+package badger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/dgraph-io/badger/v4"
+	"go.uber.org/zap"
+)
+
+// Checkpoint writes a consistent snapshot of the store into dir, which must
+// not exist yet, and returns the version it was taken at. The snapshot holds
+// the latest live version of every key, reserved ones included, and is
+// encrypted and compressed like the store. It is written next to dir first and
+// only renamed into place once complete.
+//
+// Long reads should go to a checkpoint opened with OpenCheckpoint rather than
+// to the live store, where an open read transaction holds back value log GC.
+func (db *BadgerApp) Checkpoint(ctx context.Context, dir string) (version uint64, err error) {
+	ctx, span := db.startSpan(ctx, "checkpoint", &traceOptions{spanName: "badger.Checkpoint"})
+	defer span.End()
+	defer func() { endSpan(span, &Txn{}, err) }()
+
+	if _, err := os.Stat(dir); err == nil {
+		return 0, fmt.Errorf("badger checkpoint %s already exists", dir)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	tmp := dir + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(tmp)
+		}
+	}()
+
+	opts, err := db.config.options()
+	if err != nil {
+		return 0, err
+	}
+	target, err := db.open(ctx, opts.
+		WithDir(tmp).
+		WithValueDir(tmp).
+		WithInMemory(false).
+		WithReadOnly(false).
+		WithLogger(db.badgerLogger()))
+	if err != nil {
+		return 0, err
+	}
+	err = db.streamTo(ctx, target)
+	version = target.MaxVersion()
+	if closeErr := target.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dir)
+	}
+	if err != nil {
+		return 0, err
+	}
+	db.Logger.Info("badger checkpoint written", zap.String("dir", dir), zap.Uint64("version", version))
+	return version, nil
+}
+
+// streamTo writes the latest snapshot of the store into the empty target.
+func (db *BadgerApp) streamTo(ctx context.Context, target *badger.DB) error {
+	w := target.NewStreamWriter()
+	if err := w.Prepare(); err != nil {
+		return err
+	}
+	// every producer of a stream reads in its own transaction, a single one
+	// keeps the snapshot consistent
+	stream := db.DB.NewStream()
+	stream.NumGo = 1
+	stream.LogPrefix = "badger.Checkpoint"
+	stream.Send = w.Write
+	if err := stream.Orchestrate(ctx); err != nil {
+		w.Cancel()
+		return err
+	}
+	return w.Flush()
+}
+
+// OpenCheckpoint opens the checkpoint in dir read-only, configured like db
+// but without background jobs, migrations, replication, quota or expiry
+// notifications. db does not need to be open, so
+// another process can open checkpoints with a BadgerApp configured like the
+// one that took them. The returned app is not registered in any container and
+// has to be closed by the caller.
+func (db *BadgerApp) OpenCheckpoint(ctx context.Context, dir string) (*BadgerApp, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	checkpoint := New(db.config.name)
+	checkpoint.Logger = db.Logger.With(zap.String("checkpoint", dir))
+	checkpoint.checkpoint = dir
+	checkpoint.config = db.config
+	checkpoint.config.Path = dir
+	checkpoint.config.InMemory = false
+	checkpoint.config.ReadOnly = true
+	checkpoint.config.GC.Interval = 0
+	checkpoint.config.Backup.Interval = 0
+	// the checkpoint neither follows a primary nor takes over the replication
+	// socket of db, and expiry handlers are not carried over
+	checkpoint.config.Replication = replicationConfig{}
+	checkpoint.config.Quota = quotaConfig{}
+	checkpoint.config.Expiry = expiryConfig{}
+	checkpoint.expiryHandlers = nil
+	if err := checkpoint.initialize(ctx); err != nil {
+		return nil, fmt.Errorf("open badger checkpoint %s: %w", dir, err)
+	}
+	return checkpoint, nil
+}
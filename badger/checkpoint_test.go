@@ -0,0 +1,67 @@
+package badger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCheckpoint(t *testing.T) {
+	Convey("Checkpoint", t, func() {
+		dir := t.TempDir()
+		app := newDiskApp(t, filepath.Join(dir, "db"))
+		defer app.Close(t.Context())
+
+		So(set(app, "a", "1"), ShouldBeNil)
+		So(set(app, "b", "2"), ShouldBeNil)
+		So(app.Update(func(txn *badger.Txn) error {
+			return txn.Delete([]byte("b"))
+		}), ShouldBeNil)
+		seq, err := app.Sequence("checkpoint")
+		So(err, ShouldBeNil)
+		_, err = seq.Next()
+		So(err, ShouldBeNil)
+
+		path := filepath.Join(dir, "checkpoint")
+		version, err := app.Checkpoint(t.Context(), path)
+		So(err, ShouldBeNil)
+		So(version, ShouldBeGreaterThan, 0)
+		_, err = app.Checkpoint(t.Context(), path)
+		So(err, ShouldNotBeNil)
+
+		// later writes to the store stay out of the checkpoint
+		So(set(app, "a", "changed"), ShouldBeNil)
+
+		checkpoint, err := app.OpenCheckpoint(t.Context(), path)
+		So(err, ShouldBeNil)
+		defer checkpoint.Close(t.Context())
+		So(checkpoint.DB.Opts().ReadOnly, ShouldBeTrue)
+
+		value, err := get(checkpoint, "a")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, "1")
+		_, err = get(checkpoint, "b")
+		So(err, ShouldEqual, badger.ErrKeyNotFound)
+		So(checkpoint.DB.MaxVersion(), ShouldEqual, version)
+		So(set(checkpoint, "c", "3"), ShouldEqual, badger.ErrReadOnlyTxn)
+
+		// reserved keys are part of the checkpoint
+		_, err = get(checkpoint, string(reservedKey("sequence", "checkpoint")))
+		So(err, ShouldBeNil)
+
+		// replication settings of the store are not carried over
+		socket := filepath.Join(dir, "primary.sock")
+		replica := New("")
+		replica.config = app.config
+		replica.config.Replication = replicationConfig{Listen: "unix://" + socket, Primary: "unix://" + socket}
+		checkpoint, err = replica.OpenCheckpoint(t.Context(), path)
+		So(err, ShouldBeNil)
+		defer checkpoint.Close(t.Context())
+		So(checkpoint.config.Replication, ShouldResemble, replicationConfig{})
+		_, err = os.Stat(socket)
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,427 @@
+package badger
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"text/tabwriter"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/spf13/cobra"
+)
+
+// exportRecord is a line of the JSONL export, keys and values are base64
+// encoded.
+type exportRecord struct {
+	Key       []byte `json:"key"`
+	Value     []byte `json:"value"`
+	ExpiresAt uint64 `json:"expires_at,omitempty"`
+	UserMeta  byte   `json:"user_meta,omitempty"`
+}
+
+// Command returns the admin commands of db. They read the store options from
+// the flags of db's configuration, which has to be registered once on a
+// parent command, typically on the persistent flags of the root command, so
+// that the app and the admin commands share them:
+//
+//	db := badger.New("")
+//	db.Configuration().Register(rootCmd.PersistentFlags())
+//	rootCmd.AddCommand(badger.Command(db))
+//
+// Inspection commands open the store read-only, none of them runs the
+// background gc or backups. None of them runs beside an app that has the
+// store open. rebuild-index knows the indexes declared on the
+// stores of db, declare them before executing the command.
+func Command(db *BadgerApp) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "badger",
+		Short: "Inspect and maintain the badger store",
+	}
+	if db.config.name != "" {
+		cmd.Use = "badger-" + db.config.name
+	}
+	cmd.AddCommand(
+		db.keysCommand(),
+		db.getCommand(),
+		db.statsCommand(),
+		db.tablesCommand(),
+		db.exportCommand(),
+		db.importCommand(),
+		db.flattenCommand(),
+		db.gcCommand(),
+		db.backupCommand(),
+		db.restoreCommand(),
+		db.checkpointCommand(),
+		db.rebuildIndexCommand(),
+	)
+	return cmd
+}
+
+func (db *BadgerApp) keysCommand() *cobra.Command {
+	var limit int
+	var reserved bool
+	cmd := &cobra.Command{
+		Use:   "keys [prefix]",
+		Short: "List the keys starting with prefix",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var prefix []byte
+			if len(args) > 0 {
+				prefix = []byte(args[0])
+			}
+			return db.withStore(cmd, true, func(ctx context.Context) error {
+				return db.DB.View(func(txn *badger.Txn) error {
+					opts := badger.DefaultIteratorOptions
+					opts.PrefetchValues = false
+					opts.Prefix = prefix
+					it := txn.NewIterator(opts)
+					defer it.Close()
+
+					n := 0
+					for it.Rewind(); it.Valid() && (limit <= 0 || n < limit); it.Next() {
+						if err := ctx.Err(); err != nil {
+							return err
+						}
+						key := it.Item().Key()
+						if !reserved && isReservedKey(key) {
+							continue
+						}
+						fmt.Fprintln(cmd.OutOrStdout(), printableKey(key))
+						n++
+					}
+					return nil
+				})
+			})
+		},
+	}
+	cmd.Flags().IntVar(&limit, "limit", 100, "Maximum number of keys to list, 0 lists all")
+	cmd.Flags().BoolVar(&reserved, "reserved", false, "Also list the keys the app keeps for its own bookkeeping")
+	return cmd
+}
+
+func (db *BadgerApp) getCommand() *cobra.Command {
+	var hexKey bool
+	cmd := &cobra.Command{
+		Use:   "get key",
+		Short: "Print the value of a key",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := parseKeyArg(args[0], hexKey)
+			if err != nil {
+				return err
+			}
+			return db.withStore(cmd, true, func(context.Context) error {
+				return db.DB.View(func(txn *badger.Txn) error {
+					item, err := txn.Get(key)
+					if err != nil {
+						return err
+					}
+					return item.Value(func(val []byte) error {
+						_, err := cmd.OutOrStdout().Write(val)
+						return err
+					})
+				})
+			})
+		},
+	}
+	cmd.Flags().BoolVar(&hexKey, "hex", false, "The key is hex encoded")
+	return cmd
+}
+
+func (db *BadgerApp) statsCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "stats",
+		Short: "Show the size of the store and of its LSM levels",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return db.withStore(cmd, true, func(context.Context) error {
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+				lsm, vlog := db.DB.Size()
+				fmt.Fprintf(w, "lsm size\t%d\n", lsm)
+				fmt.Fprintf(w, "vlog size\t%d\n", vlog)
+				fmt.Fprintf(w, "max version\t%d\n", db.DB.MaxVersion())
+				fmt.Fprintln(w)
+				fmt.Fprintln(w, "level\ttables\tsize\ttarget size\tscore")
+				for _, level := range db.DB.Levels() {
+					fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%.2f\n", level.Level, level.NumTables, level.Size, level.TargetSize, level.Score)
+				}
+				return w.Flush()
+			})
+		},
+	}
+}
+
+func (db *BadgerApp) tablesCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "tables",
+		Short: "Show the tables of the LSM tree",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return db.withStore(cmd, true, func(context.Context) error {
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "id\tlevel\tkeys\ton disk\tleft\tright")
+				for _, t := range db.DB.Tables() {
+					fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%s\t%s\n", t.ID, t.Level, t.KeyCount, t.OnDiskSize, printableKey(t.Left), printableKey(t.Right))
+				}
+				return w.Flush()
+			})
+		},
+	}
+}
+
+func (db *BadgerApp) exportCommand() *cobra.Command {
+	var prefix string
+	cmd := &cobra.Command{
+		Use:   "export [file]",
+		Short: "Export the latest version of every key as JSON lines, to stdout by default",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return db.withStore(cmd, true, func(ctx context.Context) error {
+				return withOutput(cmd, args, func(w io.Writer) error {
+					enc := json.NewEncoder(w)
+					return db.Scan(ctx, []byte(prefix), func(kv KV) error {
+						return enc.Encode(exportRecord{
+							Key:       kv.Key,
+							Value:     kv.Value,
+							ExpiresAt: kv.ExpiresAt,
+							UserMeta:  kv.UserMeta,
+						})
+					}, withReservedKeys())
+				})
+			})
+		},
+	}
+	cmd.Flags().StringVar(&prefix, "prefix", "", "Only export the keys starting with prefix")
+	return cmd
+}
+
+func (db *BadgerApp) importCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "import [file]",
+		Short: "Import JSON lines written by export, from stdin by default",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			r := cmd.InOrStdin()
+			if len(args) > 0 {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				r = f
+			}
+
+			return db.withStore(cmd, false, func(ctx context.Context) error {
+				var failed atomic.Int64
+				w := db.NewBatchWriter(WithErrorHandler(func(key []byte, err error) {
+					failed.Add(1)
+					fmt.Fprintf(cmd.ErrOrStderr(), "import %s: %v\n", printableKey(key), err)
+				}))
+
+				dec := json.NewDecoder(r)
+				n := 0
+				for {
+					var record exportRecord
+					if err := dec.Decode(&record); errors.Is(err, io.EOF) {
+						break
+					} else if err != nil {
+						_ = w.Close()
+						return fmt.Errorf("line %d: %w", n+1, err)
+					}
+					entry := badger.NewEntry(record.Key, record.Value).WithMeta(record.UserMeta)
+					entry.ExpiresAt = record.ExpiresAt
+					if err := w.SetEntry(ctx, entry); err != nil {
+						_ = w.Close()
+						return err
+					}
+					n++
+				}
+				if err := w.Close(); err != nil {
+					return err
+				}
+				if failed.Load() > 0 {
+					return fmt.Errorf("%d of %d keys failed to import", failed.Load(), n)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "imported %d keys\n", n)
+				return nil
+			})
+		},
+	}
+}
+
+func (db *BadgerApp) flattenCommand() *cobra.Command {
+	var workers int
+	cmd := &cobra.Command{
+		Use:   "flatten",
+		Short: "Compact every LSM level into the last one",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return db.withStore(cmd, false, func(context.Context) error {
+				return db.DB.Flatten(workers)
+			})
+		},
+	}
+	cmd.Flags().IntVar(&workers, "workers", 2, "Number of concurrent compactions")
+	return cmd
+}
+
+func (db *BadgerApp) gcCommand() *cobra.Command {
+	var discardRatio float64
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Run value log garbage collection until nothing is left to rewrite",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return db.withStore(cmd, false, func(context.Context) error {
+				rewrites, err := db.CollectGarbage(discardRatio)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "rewrote %d value log files\n", rewrites)
+				return nil
+			})
+		},
+	}
+	cmd.Flags().Float64Var(&discardRatio, "discard-ratio", 0.5, "Rewrite a value log file when at least this ratio of it can be discarded")
+	return cmd
+}
+
+func (db *BadgerApp) backupCommand() *cobra.Command {
+	var since uint64
+	var full bool
+	cmd := &cobra.Command{
+		Use:   "backup path",
+		Short: "Back up the store to a file, or to a backup directory when path is one",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return db.withStore(cmd, true, func(ctx context.Context) error {
+				if isDir(args[0]) {
+					return db.BackupToDir(ctx, args[0], full)
+				}
+				return withOutput(cmd, args, func(w io.Writer) error {
+					version, err := db.Backup(ctx, w, since)
+					if err == nil {
+						fmt.Fprintf(cmd.ErrOrStderr(), "backed up to version %d\n", version)
+					}
+					return err
+				})
+			})
+		},
+	}
+	cmd.Flags().Uint64Var(&since, "since", 0, "Only back up the versions after this one, into a file")
+	cmd.Flags().BoolVar(&full, "full", false, "Take a full backup, into a backup directory")
+	return cmd
+}
+
+func (db *BadgerApp) restoreCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "restore path",
+		Short: "Restore a backup file, or the latest backup chain of a backup directory",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return db.withStore(cmd, false, func(ctx context.Context) error {
+				if isDir(args[0]) {
+					return db.RestoreFromDir(ctx, args[0])
+				}
+				f, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				return db.Restore(ctx, f)
+			})
+		},
+	}
+}
+
+func (db *BadgerApp) checkpointCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "checkpoint dir",
+		Short: "Write a consistent snapshot of the store into a new directory",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return db.withStore(cmd, true, func(ctx context.Context) error {
+				version, err := db.Checkpoint(ctx, args[0])
+				if err == nil {
+					fmt.Fprintf(cmd.OutOrStdout(), "checkpoint at version %d\n", version)
+				}
+				return err
+			})
+		},
+	}
+}
+
+func (db *BadgerApp) rebuildIndexCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rebuild-index [name...]",
+		Short: "Rebuild the named indexes, namespace/name, or every index the app declares",
+		Args:  cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return db.withStore(cmd, false, func(ctx context.Context) error {
+				rebuilt, err := db.RebuildIndexes(ctx, args...)
+				for _, name := range rebuilt {
+					fmt.Fprintf(cmd.OutOrStdout(), "rebuilt index %s\n", name)
+				}
+				return err
+			})
+		},
+	}
+}
+
+// withStore opens the store for the duration of fn, read-only when asked to
+// and without background jobs or replication, so the commands also work on
+// followers. A running app holds the lock of its store, even the read-only
+// commands wait startup.lock_timeout for it and then fail with a *LockError:
+// stop the app first, or run them on a checkpoint of the store.
+func (db *BadgerApp) withStore(cmd *cobra.Command, readOnly bool, fn func(ctx context.Context) error) error {
+	if cmd.Flags().Lookup(db.config.key("path")) == nil {
+		return fmt.Errorf("flag --%s is not registered, register the badger configuration on a parent command", db.config.key("path"))
+	}
+
+	saved := db.config
+	defer func() { db.config = saved }()
+	db.config.ReadOnly = db.config.ReadOnly || readOnly
+	db.config.GC.Interval, db.config.Backup.Interval = 0, 0
+	db.config.Replication = replicationConfig{}
+
+	ctx := cmd.Context()
+	if err := db.initialize(ctx); err != nil {
+		return err
+	}
+	defer db.Close(ctx)
+	return fn(ctx)
+}
+
+// withOutput calls fn with the file named by the first argument, or with the
+// command output when there is none.
+func withOutput(cmd *cobra.Command, args []string, fn func(w io.Writer) error) error {
+	if len(args) == 0 {
+		return fn(cmd.OutOrStdout())
+	}
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	if err := fn(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func parseKeyArg(arg string, isHex bool) ([]byte, error) {
+	if isHex {
+		return hex.DecodeString(arg)
+	}
+	return []byte(arg), nil
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,147 @@
+package badger
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/yoshino-s/go-framework/configuration"
+)
+
+func TestCommand(t *testing.T) {
+	Convey("Command", t, func() {
+		dir := t.TempDir()
+		db := New("admin")
+		root := &cobra.Command{Use: "app"}
+		db.Configuration().Register(root.PersistentFlags())
+		root.AddCommand(Command(db))
+		assets := NewStore[string, string](db, "asset", StringKey{}, JSONCodec[string]{})
+		NewIndex(assets, "value", func(value string) [][]byte { return [][]byte{[]byte(value)} })
+
+		viper.Set("badger.admin.path", filepath.Join(dir, "db"))
+		configuration.Setup("test")
+
+		// cobra keeps the flag values of previous executions
+		resetFlags := func() {
+			for _, cmd := range root.Commands()[0].Commands() {
+				cmd.LocalFlags().VisitAll(func(f *pflag.Flag) {
+					_ = f.Value.Set(f.DefValue)
+					f.Changed = false
+				})
+			}
+		}
+		run := func(stdin string, args ...string) (string, error) {
+			resetFlags()
+			var out bytes.Buffer
+			root.SetIn(strings.NewReader(stdin))
+			root.SetOut(&out)
+			root.SetErr(&out)
+			root.SetArgs(append([]string{"badger-admin"}, args...))
+			err := root.ExecuteContext(t.Context())
+			return out.String(), err
+		}
+
+		out, err := run(`{"key":"YS8x","value":"b25l"}
+{"key":"YS8y","value":"dHdv","user_meta":7}
+{"key":"Yi8x","value":"dGhyZWU="}
+`, "import")
+		So(err, ShouldBeNil)
+		So(out, ShouldContainSubstring, "imported 3 keys")
+
+		out, err = run("", "keys", "a/")
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, "a/1\na/2\n")
+
+		out, err = run("", "get", "b/1")
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, "three")
+		out, err = run("", "get", "--hex", "612f32")
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, "two")
+		_, err = run("", "get", "missing")
+		So(err, ShouldEqual, badger.ErrKeyNotFound)
+
+		export := filepath.Join(dir, "export.jsonl")
+		_, err = run("", "export", "--prefix", "a/", export)
+		So(err, ShouldBeNil)
+
+		out, err = run("", "stats")
+		So(err, ShouldBeNil)
+		So(out, ShouldContainSubstring, "max version")
+		_, err = run("", "tables")
+		So(err, ShouldBeNil)
+		_, err = run("", "flatten")
+		So(err, ShouldBeNil)
+		_, err = run("", "gc")
+		So(err, ShouldBeNil)
+
+		backup := filepath.Join(dir, "backup")
+		_, err = run("", "backup", backup)
+		So(err, ShouldBeNil)
+
+		out, err = run("", "checkpoint", filepath.Join(dir, "checkpoint"))
+		So(err, ShouldBeNil)
+		So(out, ShouldStartWith, "checkpoint at version")
+
+		// records imported around the store are indexed by a rebuild
+		_, err = run(`{"key":"YXNzZXQAaw==","value":"InYi"}
+`, "import")
+		So(err, ShouldBeNil)
+		out, err = run("", "rebuild-index")
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, "rebuilt index asset/value\n")
+		out, err = run("", "keys", "--reserved", "--limit", "0")
+		So(err, ShouldBeNil)
+		So(out, ShouldContainSubstring, "index")
+		_, err = run("", "rebuild-index", "asset/missing")
+		So(err, ShouldNotBeNil)
+
+		// restoring into an empty store brings every key back
+		viper.Set("badger.admin.path", filepath.Join(dir, "restored"))
+		configuration.Setup("test")
+		_, err = run("", "restore", backup)
+		So(err, ShouldBeNil)
+		out, err = run("", "get", "b/1")
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, "three")
+
+		viper.Set("badger.admin.path", filepath.Join(dir, "imported"))
+		configuration.Setup("test")
+		_, err = run("", "import", export)
+		So(err, ShouldBeNil)
+		out, err = run("", "keys")
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, "a/1\na/2\n")
+
+		_, err = run("", "import", filepath.Join(dir, "missing.jsonl"))
+		So(err, ShouldNotBeNil)
+
+		// the commands do not run beside an app holding the store
+		running := newDiskApp(t, filepath.Join(dir, "imported"))
+		viper.Set("badger.admin.startup.lock_timeout", "100ms")
+		configuration.Setup("test")
+		_, err = run("", "keys")
+		So(errors.Is(err, ErrLocked), ShouldBeTrue)
+		running.Close(t.Context())
+		viper.Set("badger.admin.startup.lock_timeout", "10s")
+		configuration.Setup("test")
+
+		// the commands open followers without following their primary
+		viper.Set("badger.admin.replication.primary", "unix://"+filepath.Join(dir, "primary.sock"))
+		configuration.Setup("test")
+		defer func() {
+			viper.Set("badger.admin.replication.primary", "")
+			configuration.Setup("test")
+		}()
+		out, err = run("", "keys")
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, "a/1\na/2\n")
+	})
+}
@@ -0,0 +1,102 @@
+package badger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// KeyEncoder converts typed keys to the bytes stored in badger. Encodings
+// should preserve the ordering of keys that callers want to iterate in.
+type KeyEncoder[K any] interface {
+	EncodeKey(K) ([]byte, error)
+	DecodeKey([]byte) (K, error)
+}
+
+// Codec converts typed values to the bytes stored in badger.
+type Codec[V any] interface {
+	Marshal(V) ([]byte, error)
+	Unmarshal([]byte) (V, error)
+}
+
+var (
+	_ KeyEncoder[string] = StringKey{}
+	_ KeyEncoder[[]byte] = BytesKey{}
+	_ KeyEncoder[uint64] = Uint64Key{}
+)
+
+// StringKey stores string keys as their raw bytes.
+type StringKey struct{}
+
+func (StringKey) EncodeKey(k string) ([]byte, error) { return []byte(k), nil }
+func (StringKey) DecodeKey(b []byte) (string, error) { return string(b), nil }
+
+// BytesKey stores byte slice keys unchanged.
+type BytesKey struct{}
+
+func (BytesKey) EncodeKey(k []byte) ([]byte, error) { return k, nil }
+func (BytesKey) DecodeKey(b []byte) ([]byte, error) { return bytes.Clone(b), nil }
+
+// Uint64Key stores integer keys big-endian so they iterate in numeric order.
+type Uint64Key struct{}
+
+func (Uint64Key) EncodeKey(k uint64) ([]byte, error) {
+	return binary.BigEndian.AppendUint64(nil, k), nil
+}
+func (Uint64Key) DecodeKey(b []byte) (uint64, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("invalid uint64 key length %d", len(b))
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// JSONCodec encodes values with encoding/json.
+type JSONCodec[V any] struct{}
+
+func (JSONCodec[V]) Marshal(v V) ([]byte, error) { return json.Marshal(v) }
+func (JSONCodec[V]) Unmarshal(b []byte) (v V, err error) {
+	err = json.Unmarshal(b, &v)
+	return v, err
+}
+
+// GobCodec encodes values with encoding/gob.
+type GobCodec[V any] struct{}
+
+func (GobCodec[V]) Marshal(v V) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (GobCodec[V]) Unmarshal(b []byte) (v V, err error) {
+	err = gob.NewDecoder(bytes.NewReader(b)).Decode(&v)
+	return v, err
+}
+
+// MsgpackCodec encodes values with msgpack.
+type MsgpackCodec[V any] struct{}
+
+func (MsgpackCodec[V]) Marshal(v V) ([]byte, error) { return msgpack.Marshal(v) }
+func (MsgpackCodec[V]) Unmarshal(b []byte) (v V, err error) {
+	err = msgpack.Unmarshal(b, &v)
+	return v, err
+}
+
+// ProtoCodec encodes protobuf messages, V is the generated message pointer
+// type such as *pb.Asset.
+type ProtoCodec[V proto.Message] struct{}
+
+func (ProtoCodec[V]) Marshal(v V) ([]byte, error) { return proto.Marshal(v) }
+func (ProtoCodec[V]) Unmarshal(b []byte) (V, error) {
+	var zero V
+	msg := zero.ProtoReflect().Type().New().Interface()
+	if err := proto.Unmarshal(b, msg); err != nil {
+		return zero, err
+	}
+	return msg.(V), nil
+}
@@ -1,6 +1,12 @@
 package badger
 
 import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/dgraph-io/badger/v4/options"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"github.com/yoshino-s/go-framework/configuration"
@@ -10,15 +16,186 @@ import (
 var _ configuration.Configuration = (*config)(nil)
 
 type config struct {
-	Path string `mapstructure:"path"`
+	name string
+
+	Path              string `mapstructure:"path"`
+	InMemory          bool   `mapstructure:"in_memory"`
+	SyncWrites        bool   `mapstructure:"sync_writes"`
+	ReadOnly          bool   `mapstructure:"read_only"`
+	DetectConflicts   bool   `mapstructure:"detect_conflicts"`
+	NumVersionsToKeep int    `mapstructure:"num_versions_to_keep"`
+	ValueLogFileSize  int64  `mapstructure:"value_log_file_size"`
+	MemTableSize      int64  `mapstructure:"mem_table_size"`
+	BlockCacheSize    int64  `mapstructure:"block_cache_size"`
+	IndexCacheSize    int64  `mapstructure:"index_cache_size"`
+	Compression       string `mapstructure:"compression"`
+	LogLevel          string `mapstructure:"log_level"`
+
+	EncryptionKeyFile     string        `mapstructure:"encryption_key_file"`
+	EncryptionKeyEnv      string        `mapstructure:"encryption_key_env"`
+	EncryptionKeyRotation time.Duration `mapstructure:"encryption_key_rotation"`
+
+	GC          gcConfig          `mapstructure:"gc"`
+	Backup      backupConfig      `mapstructure:"backup"`
+	Migrations  migrationConfig   `mapstructure:"migrations"`
+	Retry       RetryPolicy       `mapstructure:"retry"`
+	Sequence    sequenceConfig    `mapstructure:"sequence"`
+	Scan        scanConfig        `mapstructure:"scan"`
+	Replication replicationConfig `mapstructure:"replication"`
+	Expiry      expiryConfig      `mapstructure:"expiry"`
+	Quota       quotaConfig       `mapstructure:"quota"`
+	Startup     startupConfig     `mapstructure:"startup"`
 }
 
 func (c *config) Register(flagSet *pflag.FlagSet) {
-	flagSet.String("badger.path", "/tmp/badger-db", "The path of badger store")
+	defaults := badger.DefaultOptions("")
+
+	flagSet.String(c.key("path"), "/tmp/badger-db", "The path of badger store")
+	flagSet.Bool(c.key("in_memory"), false, "Run badger entirely in memory, the path is ignored")
+	flagSet.Bool(c.key("sync_writes"), defaults.SyncWrites, "Sync all writes to disk before acknowledging them")
+	flagSet.Bool(c.key("read_only"), defaults.ReadOnly, "Open the badger store in read-only mode")
+	flagSet.Bool(c.key("detect_conflicts"), defaults.DetectConflicts, "Detect conflicts between concurrent transactions")
+	flagSet.Int(c.key("num_versions_to_keep"), defaults.NumVersionsToKeep, "Number of versions to keep per key")
+	flagSet.Int64(c.key("value_log_file_size"), defaults.ValueLogFileSize, "Maximum size of a single value log file in bytes")
+	flagSet.Int64(c.key("mem_table_size"), defaults.MemTableSize, "Size of each memtable in bytes")
+	flagSet.Int64(c.key("block_cache_size"), defaults.BlockCacheSize, "Size of the block cache in bytes")
+	flagSet.Int64(c.key("index_cache_size"), defaults.IndexCacheSize, "Size of the index cache in bytes, 0 keeps indices in memory")
+	flagSet.String(c.key("compression"), "snappy", "Block compression, one of none, snappy, zstd")
+	flagSet.String(c.key("log_level"), "info", "Minimum level of the badger log, one of debug, info, warning, error")
+	flagSet.String(c.key("encryption_key_file"), "", "Path of a file holding the AES encryption key (16, 24 or 32 bytes)")
+	flagSet.String(c.key("encryption_key_env"), "", "Name of an environment variable holding the AES encryption key (16, 24 or 32 bytes)")
+	flagSet.Duration(c.key("encryption_key_rotation"), defaults.EncryptionKeyRotationDuration, "Rotation interval of the data keys derived from the encryption key")
+	flagSet.Duration(c.key("gc.interval"), 10*time.Minute, "Interval of value log garbage collection, 0 disables it")
+	flagSet.Float64(c.key("gc.discard_ratio"), 0.5, "Rewrite a value log file when at least this ratio of it can be discarded")
+	flagSet.Float64(c.key("gc.busy_write_rate"), 1000, "Commits per second above which value log gc backs off, 0 never backs off")
+	flagSet.String(c.key("backup.dir"), "", "Directory of scheduled backups")
+	flagSet.Duration(c.key("backup.interval"), 0, "Interval of scheduled backups, 0 disables them")
+	flagSet.Int(c.key("backup.full_every"), 24, "Take a full backup after this many backups in a chain, 0 only takes incremental backups")
+	flagSet.Int(c.key("backup.retention_count"), 0, "Number of backups to keep, 0 keeps all")
+	flagSet.Duration(c.key("backup.retention_age"), 0, "Maximum age of kept backups, 0 keeps all")
+	flagSet.Int(c.key("retry.max_attempts"), 5, "Number of times an update conflicting with another one runs at most")
+	flagSet.Duration(c.key("retry.initial_backoff"), 10*time.Millisecond, "Wait before retrying a conflicting update, doubled for every further retry")
+	flagSet.Duration(c.key("retry.max_backoff"), time.Second, "Maximum wait between retries of a conflicting update")
+	flagSet.Float64(c.key("retry.jitter"), 0.5, "Fraction of the retry wait randomly taken off")
+	flagSet.Uint64(c.key("sequence.bandwidth"), defaultSequenceBandwidth, "Number of ids a sequence leases at once")
+	flagSet.Int(c.key("scan.goroutines"), 8, "Number of goroutines reading key ranges in a scan")
+	flagSet.Bool(c.key("migrations.dry_run"), false, "Report what pending migrations would change without applying them")
+	flagSet.String(c.key("replication.listen"), "", "Address followers replicate from, host:port or unix:///path/to/socket, empty disables it")
+	flagSet.String(c.key("replication.primary"), "", "Primary to follow, http://host:port or unix:///path/to/socket, empty disables following")
+	flagSet.Duration(c.key("replication.heartbeat"), time.Second, "Interval of the heartbeats a primary sends to its followers")
+	flagSet.String(c.key("replication.token_file"), "", "Path of a file holding the token followers authenticate with, required to listen on a non-loopback address")
+	flagSet.Int64(c.key("quota.soft_limit"), 0, "Size in bytes of the LSM tree and value log above which a warning is raised, 0 disables it")
+	flagSet.Int64(c.key("quota.hard_limit"), 0, "Size in bytes of the LSM tree and value log above which writes are rejected, 0 disables it")
+	flagSet.Int64(c.key("quota.soft_min_free"), 0, "Free bytes on the filesystem below which a warning is raised, 0 disables it")
+	flagSet.Int64(c.key("quota.hard_min_free"), 0, "Free bytes on the filesystem below which writes are rejected, 0 disables it")
+	flagSet.Duration(c.key("quota.interval"), 10*time.Second, "Interval at which the size of the store and the free space are checked")
+	flagSet.Duration(c.key("expiry.interval"), time.Second, "Interval at which expired keys are reported to their handlers, 0 disables reporting")
+	flagSet.Duration(c.key("startup.lock_timeout"), 10*time.Second, "How long to wait for another process to release the directory lock of the store")
+	flagSet.Bool(c.key("startup.auto_truncate"), false, "Truncate unreadable memtable logs and the last value log on open, opening read-only stores read-write for it, losing the writes they hold")
 	utils.MustNoError(viper.BindPFlags(flagSet))
 	configuration.Register(c)
 }
 
 func (c *config) Read() {
-	utils.MustDecodeFromMapstructure(viper.AllSettings()["badger"], c)
+	settings := viper.AllSettings()["badger"]
+	if c.name != "" {
+		instances, _ := settings.(map[string]any)
+		settings = instances[c.name]
+	}
+	utils.MustDecodeFromMapstructure(settings, c)
+}
+
+// isConfigKey reports whether name is a setting of the default instance and
+// thus cannot name another one.
+func isConfigKey(name string) bool {
+	t := reflect.TypeFor[config]()
+	for i := range t.NumField() {
+		if t.Field(i).Tag.Get("mapstructure") == name {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the full configuration key of the instance setting name.
+func (c *config) key(name string) string {
+	if c.name == "" {
+		return "badger." + name
+	}
+	return "badger." + c.name + "." + name
+}
+
+// options builds the badger options described by the configuration.
+func (c *config) options() (badger.Options, error) {
+	compression, err := parseCompression(c.Compression)
+	if err != nil {
+		return badger.Options{}, err
+	}
+
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		return badger.Options{}, fmt.Errorf("%s: %w", c.key("log_level"), err)
+	}
+
+	if c.GC.Interval > 0 && (c.GC.DiscardRatio <= 0 || c.GC.DiscardRatio >= 1) {
+		return badger.Options{}, fmt.Errorf("%s must be in (0, 1), got %v", c.key("gc.discard_ratio"), c.GC.DiscardRatio)
+	}
+
+	if c.Backup.Interval > 0 && c.Backup.Dir == "" {
+		return badger.Options{}, fmt.Errorf("%s must be set when %s is positive", c.key("backup.dir"), c.key("backup.interval"))
+	}
+
+	if err := c.Quota.validate(); err != nil {
+		return badger.Options{}, fmt.Errorf("%s: %w", c.key("quota"), err)
+	}
+
+	if err := c.Retry.validate(); err != nil {
+		return badger.Options{}, fmt.Errorf("%s: %w", c.key("retry"), err)
+	}
+
+	if err := c.Replication.validate(); err != nil {
+		return badger.Options{}, fmt.Errorf("%s: %w", c.key("replication"), err)
+	}
+
+	if c.Replication.Primary != "" && c.ReadOnly {
+		return badger.Options{}, fmt.Errorf("%s cannot be set on a read-only store", c.key("replication.primary"))
+	}
+
+	key, err := c.encryptionKey()
+	if err != nil {
+		return badger.Options{}, err
+	}
+
+	opts := badger.DefaultOptions(c.Path)
+	if c.InMemory {
+		opts = badger.DefaultOptions("").WithInMemory(true)
+	}
+	if len(key) > 0 && c.BlockCacheSize <= 0 {
+		return badger.Options{}, fmt.Errorf("%s must be positive when encryption is enabled", c.key("block_cache_size"))
+	}
+
+	return opts.
+		WithEncryptionKey(key).
+		WithEncryptionKeyRotationDuration(c.EncryptionKeyRotation).
+		WithSyncWrites(c.SyncWrites).
+		WithReadOnly(c.ReadOnly).
+		WithDetectConflicts(c.DetectConflicts).
+		WithNumVersionsToKeep(c.NumVersionsToKeep).
+		WithValueLogFileSize(c.ValueLogFileSize).
+		WithMemTableSize(c.MemTableSize).
+		WithBlockCacheSize(c.BlockCacheSize).
+		WithIndexCacheSize(c.IndexCacheSize).
+		WithCompression(compression), nil
+}
+
+func parseCompression(s string) (options.CompressionType, error) {
+	switch s {
+	case "none":
+		return options.None, nil
+	case "", "snappy":
+		return options.Snappy, nil
+	case "zstd":
+		return options.ZSTD, nil
+	default:
+		return options.None, fmt.Errorf("unknown badger compression %q, expected one of none, snappy, zstd", s)
+	}
 }
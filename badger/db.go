@@ -2,11 +2,16 @@ package badger
 
 import (
 	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
 
 	"github.com/dgraph-io/badger/v4"
 	"github.com/yoshino-s/go-framework/application"
 	"github.com/yoshino-s/go-framework/configuration"
-	"github.com/yoshino-s/go-framework/utils"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
 )
 
 var _ application.Application = (*BadgerApp)(nil)
@@ -15,11 +20,43 @@ type BadgerApp struct {
 	*application.EmptyApplication
 	*badger.DB
 	config config
+
+	background context.Context
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+	closeMu    sync.Mutex
+	stats      stats
+	metrics    metric.Registration
+	migrations []migration
+	checkpoint string
+
+	expiryHandlers []expiryHandler
+	quota          quotaState
+
+	sequencesMu sync.Mutex
+	sequences   map[string]*Sequence
+
+	indexesMu sync.Mutex
+	indexes   []registeredIndex
+
+	replication replicationState
 }
 
-func New() *BadgerApp {
+// New creates a badger store. An empty name configures it under the badger
+// section, any other name under badger.<name>, so several stores can live in
+// one binary. The application container holds one instance per type, register
+// each further store under its own type embedding *BadgerApp.
+func New(name string) *BadgerApp {
+	if strings.Contains(name, ".") || name != "" && isConfigKey(name) {
+		panic(fmt.Errorf("invalid badger instance name %q", name))
+	}
+	appName := "Badger"
+	if name != "" {
+		appName += "." + name
+	}
 	return &BadgerApp{
-		EmptyApplication: application.NewEmptyApplication("Badger"),
+		EmptyApplication: application.NewEmptyApplication(appName),
+		config:           config{name: name},
 	}
 }
 
@@ -27,10 +64,121 @@ func (db *BadgerApp) Configuration() configuration.Configuration {
 	return &db.config
 }
 
-func (db *BadgerApp) Initialize(context.Context) {
-	db.DB = utils.Must(badger.Open(badger.DefaultOptions(db.config.Path).WithLogger(&logger{db.Logger.Sugar()})))
+func (db *BadgerApp) Initialize(ctx context.Context) {
+	if err := db.initialize(ctx); err != nil {
+		db.Logger.Error("open badger store failed", zap.Error(err))
+		panic(err)
+	}
+}
+
+// initialize opens the store, migrates it and starts the background jobs.
+// The store is closed again when any step fails.
+func (db *BadgerApp) initialize(ctx context.Context) (err error) {
+	opts, err := db.config.options()
+	if err != nil {
+		return err
+	}
+	if db.DB, err = db.open(ctx, opts.WithLogger(db.badgerLogger())); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			db.unregisterMetrics()
+			_ = db.DB.Close()
+			db.DB = nil
+		}
+	}()
+
+	if db.metrics, err = db.registerMetrics(); err != nil {
+		return err
+	}
+	if err := db.runMigrations(ctx); err != nil {
+		return err
+	}
+
+	if db.replication.token, err = db.config.Replication.token(); err != nil {
+		return err
+	}
+	if db.config.Replication.Primary != "" {
+		if err := db.loadReplicationCursor(); err != nil {
+			return err
+		}
+	}
+	var listener net.Listener
+	if db.config.Replication.Listen != "" {
+		if listener, err = listenReplication(db.config.Replication.Listen); err != nil {
+			return fmt.Errorf("listen on %s: %w", db.config.key("replication.listen"), err)
+		}
+	}
+
+	limits := db.config.Quota.QuotaLimits
+	db.quota.limits.Store(&limits)
+	if limits.enabled() {
+		db.updateQuota()
+	}
+
+	db.background, db.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	if db.config.GC.Interval > 0 && !opts.InMemory && !opts.ReadOnly {
+		db.goBackground(db.runGC)
+	}
+	if db.config.Backup.Interval > 0 {
+		db.goBackground(db.runBackup)
+	}
+	if db.config.Quota.Interval > 0 {
+		db.goBackground(db.runQuota)
+	}
+	if len(db.expiryHandlers) > 0 && !opts.ReadOnly {
+		db.goBackground(db.runExpiryTracker)
+		if db.config.Expiry.Interval > 0 {
+			db.goBackground(db.runExpirySweeper)
+		}
+	}
+	if db.config.Replication.Primary != "" {
+		db.goBackground(db.runReplication)
+	}
+	if listener != nil {
+		db.goBackground(db.serveReplicationOn(listener))
+	}
+	return nil
 }
 
+// Close stops the background jobs and closes the store. Failures are logged,
+// shutdown goes on regardless. Close may be called more than once, also
+// concurrently, the store must not be used once it started.
 func (db *BadgerApp) Close(context.Context) {
-	utils.MustNoError(db.DB.Close())
+	db.closeMu.Lock()
+	defer db.closeMu.Unlock()
+	if db.DB == nil {
+		return
+	}
+	db.cancel()
+	db.wg.Wait()
+	db.unregisterMetrics()
+	if err := db.releaseSequences(); err != nil {
+		db.Logger.Error("release badger sequences failed", zap.Error(err))
+	}
+	if err := db.DB.Close(); err != nil {
+		db.Logger.Error("close badger store failed", zap.Error(err))
+	}
+	db.DB = nil
+}
+
+// Update runs fn in a read-write transaction like badger.DB.Update and counts
+// the transactions aborted by a conflict. It fails with a *QuotaError without
+// running fn while a hard limit of the quota is exceeded.
+func (db *BadgerApp) Update(fn func(txn *badger.Txn) error) error {
+	if err := db.checkQuota(); err != nil {
+		return err
+	}
+	return db.update(fn)
+}
+
+// goBackground runs fn in a goroutine whose context is cancelled in Close,
+// which then waits for it to return.
+func (db *BadgerApp) goBackground(fn func(context.Context)) {
+	db.wg.Add(1)
+	go func() {
+		defer db.wg.Done()
+		fn(db.background)
+	}()
 }
@@ -0,0 +1,167 @@
+package badger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/dgraph-io/badger/v4/options"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/yoshino-s/go-framework/configuration"
+)
+
+func newTestApp(t *testing.T) *BadgerApp {
+	app := New("")
+	flagSet := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	app.Configuration().Register(flagSet)
+
+	viper.Set("badger.in_memory", true)
+	configuration.Setup("test")
+
+	app.Initialize(t.Context())
+	t.Cleanup(func() { app.Close(t.Context()) })
+	return app
+}
+
+// testConfig configures a small store in dir without going through the
+// global configuration.
+func testConfig(dir string) config {
+	return config{
+		Path:              dir,
+		NumVersionsToKeep: 1,
+		MemTableSize:      16 << 20,
+		ValueLogFileSize:  1 << 20,
+		BlockCacheSize:    1 << 20,
+	}
+}
+
+// newMemoryApp opens an in-memory store.
+func newMemoryApp(t *testing.T) *BadgerApp {
+	app := New("")
+	app.config = testConfig("")
+	app.config.InMemory = true
+	app.config.DetectConflicts = true
+	app.Initialize(t.Context())
+	t.Cleanup(func() { app.Close(t.Context()) })
+	return app
+}
+
+// newDiskApp opens the store in dir, after configure adjusted the app. It has
+// to be closed by the caller so that tests can reopen it.
+func newDiskApp(t *testing.T, dir string, configure ...func(app *BadgerApp)) *BadgerApp {
+	app := New("")
+	app.config = testConfig(dir)
+	for _, f := range configure {
+		f(app)
+	}
+	app.Initialize(t.Context())
+	return app
+}
+
+func TestBadgerApp(t *testing.T) {
+	app := newTestApp(t)
+
+	Convey("Options", t, func() {
+		opts := app.DB.Opts()
+		So(opts.InMemory, ShouldBeTrue)
+		So(opts.Compression, ShouldEqual, options.Snappy)
+		So(opts.DetectConflicts, ShouldBeTrue)
+
+		_, err := (&config{Compression: "lz4"}).options()
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("ReadWrite", t, func() {
+		So(app.Update(func(txn *badger.Txn) error {
+			return txn.Set([]byte("key"), []byte("value"))
+		}), ShouldBeNil)
+
+		So(app.View(func(txn *badger.Txn) error {
+			item, err := txn.Get([]byte("key"))
+			So(err, ShouldBeNil)
+			value, err := item.ValueCopy(nil)
+			So(string(value), ShouldEqual, "value")
+			return err
+		}), ShouldBeNil)
+	})
+}
+
+func TestEncryption(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+
+	open := func(c config) error {
+		opts, err := c.options()
+		if err != nil {
+			return err
+		}
+		db, err := New("").open(t.Context(), opts.WithLogger(nil))
+		if err != nil {
+			return err
+		}
+		return db.Close()
+	}
+
+	Convey("Encryption", t, func() {
+		So(os.WriteFile(keyFile, []byte("0123456789abcdef\n"), 0600), ShouldBeNil)
+
+		c := config{
+			Path:                  filepath.Join(dir, "db"),
+			NumVersionsToKeep:     1,
+			MemTableSize:          16 << 20,
+			ValueLogFileSize:      1 << 20,
+			BlockCacheSize:        1 << 20,
+			EncryptionKeyFile:     keyFile,
+			EncryptionKeyRotation: time.Hour,
+		}
+		So(open(c), ShouldBeNil)
+
+		t.Setenv("TEST_BADGER_KEY", "fedcba9876543210")
+		c.EncryptionKeyFile, c.EncryptionKeyEnv = "", "TEST_BADGER_KEY"
+		So(open(c), ShouldWrap, badger.ErrEncryptionKeyMismatch)
+
+		c.EncryptionKeyEnv = ""
+		err := open(c)
+		So(err, ShouldWrap, badger.ErrEncryptionKeyMismatch)
+		So(err.Error(), ShouldContainSubstring, "no encryption key")
+
+		t.Setenv("TEST_BADGER_KEY", "short")
+		c.EncryptionKeyEnv = "TEST_BADGER_KEY"
+		So(open(c), ShouldNotBeNil)
+	})
+}
+
+func TestNamedInstances(t *testing.T) {
+	Convey("Named instances", t, func() {
+		flagSet := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		hot, cold := New("hot"), New("cold")
+		hot.Configuration().Register(flagSet)
+		cold.Configuration().Register(flagSet)
+		So(flagSet.Lookup("badger.hot.path"), ShouldNotBeNil)
+		So(flagSet.Lookup("badger.cold.gc.interval"), ShouldNotBeNil)
+
+		viper.Set("badger.hot.in_memory", true)
+		viper.Set("badger.cold.in_memory", true)
+		viper.Set("badger.cold.compression", "zstd")
+		configuration.Setup("test")
+
+		for _, app := range []*BadgerApp{hot, cold} {
+			app.Initialize(t.Context())
+			defer app.Close(t.Context())
+		}
+		So(hot.Name, ShouldEqual, "Badger.hot")
+		So(hot.DB.Opts().Compression, ShouldEqual, options.Snappy)
+		So(cold.DB.Opts().Compression, ShouldEqual, options.ZSTD)
+
+		So(set(hot, "key", "value"), ShouldBeNil)
+		_, err := get(cold, "key")
+		So(err, ShouldEqual, badger.ErrKeyNotFound)
+
+		So(func() { New("gc") }, ShouldPanic)
+		So(func() { New("a.b") }, ShouldPanic)
+	})
+}
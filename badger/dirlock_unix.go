@@ -0,0 +1,31 @@
+//go:build linux || darwin
+
+package badger
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// dirLocked reports whether another handle holds the flock badger takes on
+// dir, exclusive for read-write and shared for read-only opens.
+func dirLocked(dir string, readOnly bool) (bool, error) {
+	f, err := os.Open(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	how := syscall.LOCK_EX | syscall.LOCK_NB
+	if readOnly {
+		how = syscall.LOCK_SH | syscall.LOCK_NB
+	}
+	if err := syscall.Flock(int(f.Fd()), how); errors.Is(err, syscall.EWOULDBLOCK) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	return false, syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,42 @@
+package badger
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// encryptionKey loads the encryption key from the configured file or
+// environment variable. It returns nil when encryption is disabled.
+func (c *config) encryptionKey() ([]byte, error) {
+	var key []byte
+	var source string
+
+	switch {
+	case c.EncryptionKeyFile != "" && c.EncryptionKeyEnv != "":
+		return nil, fmt.Errorf("%s and %s are mutually exclusive", c.key("encryption_key_file"), c.key("encryption_key_env"))
+	case c.EncryptionKeyFile != "":
+		content, err := os.ReadFile(c.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read badger encryption key: %w", err)
+		}
+		key = bytes.TrimRight(content, "\r\n")
+		source = fmt.Sprintf("file %s", c.EncryptionKeyFile)
+	case c.EncryptionKeyEnv != "":
+		value, ok := os.LookupEnv(c.EncryptionKeyEnv)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("badger encryption key environment variable %s is not set", c.EncryptionKeyEnv)
+		}
+		key = []byte(value)
+		source = fmt.Sprintf("environment variable %s", c.EncryptionKeyEnv)
+	default:
+		return nil, nil
+	}
+
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("badger encryption key from %s is %d bytes, expected 16, 24 or 32", source, len(key))
+	}
+}
@@ -0,0 +1,265 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"go.uber.org/zap"
+)
+
+// expiryBatchSize is the number of keys tracked or swept per transaction.
+const expiryBatchSize = 100
+
+type expiryConfig struct {
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// ExpiredKey is a key whose TTL passed, with the last value written to it.
+type ExpiredKey struct {
+	Key       []byte
+	Value     []byte
+	ExpiresAt uint64
+	UserMeta  byte
+}
+
+type expiryHandler struct {
+	prefix  []byte
+	handler func(ctx context.Context, key ExpiredKey) error
+}
+
+// expiryWatchName names the watch of the expiry tracker, Watch rejects names
+// containing 0x00 so no watch of the application shares its cursor.
+const expiryWatchName = "\x00expiry"
+
+var (
+	expiryDuePrefix     = append(reservedKey("expiry", "due"), 0)
+	expiryTrackedPrefix = append(reservedKey("expiry", "tracked"), 0)
+)
+
+// OnExpire calls handler with every key under prefix that expires. The keys
+// written with a TTL under the registered prefixes are tracked along with
+// their last value, which badger drops on expiry, and a sweeper hands them to
+// their handlers once expired. Deleted keys are not reported. Notifications
+// are delivered at least once, across restarts, and may come up to the sweep
+// interval late. A failing handler is retried on the next sweep. Handlers
+// have to be registered before the app is initialized.
+func (db *BadgerApp) OnExpire(prefix []byte, handler func(ctx context.Context, key ExpiredKey) error) {
+	db.expiryHandlers = append(db.expiryHandlers, expiryHandler{bytes.Clone(prefix), handler})
+}
+
+func expiryDueKey(expiresAt uint64, key []byte) []byte {
+	return append(binary.BigEndian.AppendUint64(bytes.Clone(expiryDuePrefix), expiresAt), key...)
+}
+
+func expiryTrackedKey(key []byte) []byte {
+	return append(bytes.Clone(expiryTrackedPrefix), key...)
+}
+
+// runExpiryTracker follows the changes under the registered prefixes and
+// tracks the keys written with a TTL. The watch is named, so the changes
+// made while the app was down are caught up on start.
+func (db *BadgerApp) runExpiryTracker(ctx context.Context) {
+	prefixes := make([][]byte, 0, len(db.expiryHandlers))
+	for _, h := range db.expiryHandlers {
+		prefixes = append(prefixes, h.prefix)
+	}
+	for {
+		err := db.watch(ctx, expiryWatchName, prefixes, db.trackExpiry)
+		if ctx.Err() != nil {
+			return
+		}
+		db.Logger.Warn("badger expiry tracking interrupted", zap.Error(err))
+		if sleep(ctx, time.Second) != nil {
+			return
+		}
+	}
+}
+
+func (db *BadgerApp) trackExpiry(ctx context.Context, changes []Change) error {
+	for start := 0; start < len(changes); start += expiryBatchSize {
+		batch := changes[start:min(start+expiryBatchSize, len(changes))]
+		err := db.UpdateWithRetry(ctx, func(txn *Txn) error {
+			for _, c := range batch {
+				if err := trackChange(txn.Txn, c); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, WithSpanName("badger.trackExpiry"), quotaExempt())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trackChange replaces the tracked expiry of the changed key.
+func trackChange(txn *badger.Txn, c Change) error {
+	trackedKey := expiryTrackedKey(c.Key)
+	tracked, err := trackedExpiry(txn, trackedKey)
+	if err != nil {
+		return err
+	}
+	// a key that expired before it was caught up comes without its value,
+	// keep the one tracked earlier
+	if tracked == c.ExpiresAt && len(c.Value) == 0 && c.ExpiresAt <= uint64(time.Now().Unix()) {
+		return nil
+	}
+	if tracked != 0 && tracked != c.ExpiresAt {
+		if err := txn.Delete(expiryDueKey(tracked, c.Key)); err != nil {
+			return err
+		}
+	}
+	if c.ExpiresAt == 0 {
+		if tracked == 0 {
+			return nil
+		}
+		return txn.Delete(trackedKey)
+	}
+	if err := txn.SetEntry(badger.NewEntry(expiryDueKey(c.ExpiresAt, c.Key), c.Value).WithMeta(c.UserMeta)); err != nil {
+		return err
+	}
+	return txn.Set(trackedKey, binary.BigEndian.AppendUint64(nil, c.ExpiresAt))
+}
+
+func trackedExpiry(txn *badger.Txn, trackedKey []byte) (uint64, error) {
+	item, err := txn.Get(trackedKey)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	var expiresAt uint64
+	err = item.Value(func(val []byte) error {
+		if len(val) != 8 {
+			return fmt.Errorf("invalid tracked expiry of %s", printableKey(trackedKey[len(expiryTrackedPrefix):]))
+		}
+		expiresAt = binary.BigEndian.Uint64(val)
+		return nil
+	})
+	return expiresAt, err
+}
+
+func (db *BadgerApp) runExpirySweeper(ctx context.Context) {
+	ticker := time.NewTicker(db.config.Expiry.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := db.sweepExpired(ctx); err != nil && ctx.Err() == nil {
+			db.Logger.Warn("badger expiry sweep failed", zap.Error(err))
+		}
+	}
+}
+
+// sweepExpired notifies the handlers of the tracked keys that expired and
+// stops tracking them once every handler succeeded.
+func (db *BadgerApp) sweepExpired(ctx context.Context) error {
+	now := uint64(time.Now().Unix())
+	var errs []error
+	var seek []byte
+	for {
+		var expired []ExpiredKey
+		var done bool
+		err := db.DB.View(func(txn *badger.Txn) error {
+			expired, seek, done = dueKeys(txn, seek, now)
+			for i := range expired {
+				// a key written again since is tracked again by the
+				// watch, the entry is left for it to replace
+				_, err := txn.Get(expired[i].Key)
+				if err == nil {
+					expired[i].Key = nil
+				} else if !errors.Is(err, badger.ErrKeyNotFound) {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range expired {
+			if key.Key == nil {
+				continue
+			}
+			if err := db.notifyExpired(ctx, key); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if done || ctx.Err() != nil {
+			return errors.Join(append(errs, ctx.Err())...)
+		}
+	}
+}
+
+// dueKeys returns a batch of tracked keys expired at now, starting after
+// seek, the key to continue from and whether there are no more.
+func dueKeys(txn *badger.Txn, seek []byte, now uint64) ([]ExpiredKey, []byte, bool) {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = expiryDuePrefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	var expired []ExpiredKey
+	if seek == nil {
+		it.Rewind()
+	} else {
+		it.Seek(seek)
+	}
+	for ; it.Valid(); it.Next() {
+		if len(expired) == expiryBatchSize {
+			return expired, it.Item().KeyCopy(nil), false
+		}
+		item := it.Item()
+		rest := item.Key()[len(expiryDuePrefix):]
+		expiresAt := binary.BigEndian.Uint64(rest)
+		if expiresAt > now {
+			break
+		}
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			// kept for the next sweep
+			continue
+		}
+		expired = append(expired, ExpiredKey{
+			Key:       bytes.Clone(rest[8:]),
+			Value:     value,
+			ExpiresAt: expiresAt,
+			UserMeta:  item.UserMeta(),
+		})
+	}
+	return expired, nil, true
+}
+
+func (db *BadgerApp) notifyExpired(ctx context.Context, key ExpiredKey) error {
+	for _, h := range db.expiryHandlers {
+		if !bytes.HasPrefix(key.Key, h.prefix) {
+			continue
+		}
+		if err := h.handler(ctx, key); err != nil {
+			return fmt.Errorf("expiry of %s: %w", printableKey(key.Key), err)
+		}
+	}
+	return db.UpdateWithRetry(ctx, func(txn *Txn) error {
+		trackedKey := expiryTrackedKey(key.Key)
+		tracked, err := trackedExpiry(txn.Txn, trackedKey)
+		if err != nil {
+			return err
+		}
+		if tracked == key.ExpiresAt {
+			if err := txn.Delete(trackedKey); err != nil {
+				return err
+			}
+		}
+		return txn.Delete(expiryDueKey(key.ExpiresAt, key.Key))
+	}, WithSpanName("badger.notifyExpired"), quotaExempt())
+}
@@ -0,0 +1,144 @@
+package badger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newExpiryApp(t *testing.T, dir string, handler func(context.Context, ExpiredKey) error) *BadgerApp {
+	return newDiskApp(t, dir, func(app *BadgerApp) {
+		app.config.Expiry = expiryConfig{Interval: 50 * time.Millisecond}
+		app.OnExpire([]byte("asset/"), handler)
+	})
+}
+
+func setTTL(app *BadgerApp, key, value string, ttl time.Duration) error {
+	return app.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(badger.NewEntry([]byte(key), []byte(value)).WithTTL(ttl))
+	})
+}
+
+func isTracked(app *BadgerApp, key string) bool {
+	_, err := get(app, string(expiryTrackedKey([]byte(key))))
+	return err == nil
+}
+
+func TestExpiry(t *testing.T) {
+	Convey("Expiry notifications", t, func() {
+		dir := t.TempDir()
+		var mu sync.Mutex
+		expired := map[string]string{}
+		failures := 1
+		handler := func(ctx context.Context, key ExpiredKey) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if string(key.Key) == "asset/flaky" && failures > 0 {
+				failures--
+				return errors.New("unavailable")
+			}
+			expired[string(key.Key)] = string(key.Value)
+			return nil
+		}
+		seen := func(key string) func() bool {
+			return func() bool {
+				mu.Lock()
+				defer mu.Unlock()
+				_, ok := expired[key]
+				return ok
+			}
+		}
+
+		app := newExpiryApp(t, dir, handler)
+		So(setTTL(app, "asset/a", "first", time.Hour), ShouldBeNil)
+		So(setTTL(app, "asset/a", "last", time.Second), ShouldBeNil)
+		So(setTTL(app, "asset/flaky", "value", time.Second), ShouldBeNil)
+		So(setTTL(app, "asset/deleted", "value", time.Second), ShouldBeNil)
+		So(setTTL(app, "asset/kept", "value", time.Second), ShouldBeNil)
+		So(setTTL(app, "other/a", "value", time.Second), ShouldBeNil)
+		So(set(app, "asset/plain", "value"), ShouldBeNil)
+		So(waitFor(5*time.Second, func() bool { return isTracked(app, "asset/kept") }), ShouldBeTrue)
+
+		So(app.Update(func(txn *badger.Txn) error {
+			return txn.Delete([]byte("asset/deleted"))
+		}), ShouldBeNil)
+		So(set(app, "asset/kept", "forever"), ShouldBeNil)
+
+		So(waitFor(5*time.Second, seen("asset/a")), ShouldBeTrue)
+		So(waitFor(5*time.Second, seen("asset/flaky")), ShouldBeTrue)
+		mu.Lock()
+		So(expired["asset/a"], ShouldEqual, "last")
+		So(expired, ShouldNotContainKey, "asset/deleted")
+		So(expired, ShouldNotContainKey, "asset/kept")
+		So(expired, ShouldNotContainKey, "other/a")
+		So(expired, ShouldNotContainKey, "asset/plain")
+		mu.Unlock()
+		So(isTracked(app, "asset/a"), ShouldBeFalse)
+
+		// keys expiring while the app is down are reported after a restart
+		So(setTTL(app, "asset/offline", "gone", time.Second), ShouldBeNil)
+		So(waitFor(5*time.Second, func() bool { return isTracked(app, "asset/offline") }), ShouldBeTrue)
+		app.Close(t.Context())
+		time.Sleep(2 * time.Second)
+
+		app = newExpiryApp(t, dir, handler)
+		defer app.Close(t.Context())
+		So(waitFor(5*time.Second, seen("asset/offline")), ShouldBeTrue)
+		mu.Lock()
+		So(expired["asset/offline"], ShouldEqual, "gone")
+		mu.Unlock()
+	})
+}
+
+func TestExpiryTracking(t *testing.T) {
+	Convey("Expiry tracking", t, func() {
+		dir := t.TempDir()
+		ignore := func(context.Context, ExpiredKey) error { return nil }
+		app := newExpiryApp(t, dir, ignore)
+		So(setTTL(app, "asset/first", "value", time.Hour), ShouldBeNil)
+		So(waitFor(5*time.Second, func() bool { return isTracked(app, "asset/first") }), ShouldBeTrue)
+		app.Close(t.Context())
+
+		// a transaction larger than a catch-up batch written while the
+		// tracker is down is tracked whole
+		plain := newDiskApp(t, dir)
+		So(plain.Update(func(txn *badger.Txn) error {
+			for i := range 1500 {
+				entry := badger.NewEntry(fmt.Appendf(nil, "asset/bulk/%04d", i), []byte("value")).WithTTL(time.Hour)
+				if err := txn.SetEntry(entry); err != nil {
+					return err
+				}
+			}
+			return nil
+		}), ShouldBeNil)
+		plain.Close(t.Context())
+
+		app = newExpiryApp(t, dir, ignore)
+		tracked := string(expiryTrackedKey([]byte("asset/bulk/")))
+		So(waitFor(5*time.Second, func() bool { return countKeys(app, tracked) == 1500 }), ShouldBeTrue)
+
+		// the watches of the application have cursors of their own
+		So(app.Watch(t.Context(), expiryWatchName, [][]byte{nil}, nil), ShouldNotBeNil)
+		var c collector
+		stop := c.watch(t, app, "expiry", "")
+		So(waitFor(5*time.Second, func() bool { _, ok := c.get("asset/bulk/1499"); return ok }), ShouldBeTrue)
+		stop()
+		So(setTTL(app, "asset/after", "value", time.Hour), ShouldBeNil)
+		So(waitFor(5*time.Second, func() bool { return isTracked(app, "asset/after") }), ShouldBeTrue)
+		app.Close(t.Context())
+		app = newExpiryApp(t, dir, ignore)
+		So(isTracked(app, "asset/first"), ShouldBeTrue)
+		cursor, err := (&watcher{db: app, name: expiryWatchName}).loadCursor()
+		So(err, ShouldBeNil)
+		userCursor, err := (&watcher{db: app, name: "expiry"}).loadCursor()
+		So(err, ShouldBeNil)
+		So(cursor, ShouldBeGreaterThan, userCursor)
+		app.Close(t.Context())
+	})
+}
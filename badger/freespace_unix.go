@@ -0,0 +1,21 @@
+//go:build linux || darwin
+
+package badger
+
+import "syscall"
+
+// freeSpace returns the space available to the process on the filesystems
+// holding dirs, the least of them.
+func freeSpace(dirs ...string) (int64, error) {
+	free := int64(-1)
+	for _, dir := range dirs {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(dir, &st); err != nil {
+			return 0, err
+		}
+		if avail := int64(st.Bavail) * int64(st.Bsize); free < 0 || avail < free {
+			free = avail
+		}
+	}
+	return free, nil
+}
@@ -0,0 +1,98 @@
+package badger
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"go.uber.org/zap"
+)
+
+type gcConfig struct {
+	Interval      time.Duration `mapstructure:"interval"`
+	DiscardRatio  float64       `mapstructure:"discard_ratio"`
+	BusyWriteRate float64       `mapstructure:"busy_write_rate"`
+}
+
+// maxGCBackoff bounds how far the GC loop stretches its interval while the
+// store is busy.
+const maxGCBackoff = 8
+
+// CollectGarbage runs value log GC until a pass no longer rewrites a file and
+// returns the number of rewritten files.
+func (db *BadgerApp) CollectGarbage(discardRatio float64) (int, error) {
+	rewrites := 0
+	for {
+		err := db.DB.RunValueLogGC(discardRatio)
+		if errors.Is(err, badger.ErrNoRewrite) {
+			return rewrites, nil
+		}
+		if err != nil {
+			return rewrites, err
+		}
+		rewrites++
+	}
+}
+
+// committedVersion returns the version of the last commit. Unlike
+// DB.MaxVersion it is safe to call while the store is written to.
+func (db *BadgerApp) committedVersion() uint64 {
+	txn := db.DB.NewTransaction(false)
+	defer txn.Discard()
+	return txn.ReadTs()
+}
+
+func (db *BadgerApp) runGC(ctx context.Context) {
+	conf := db.config.GC
+	backoff := 1
+	lastVersion := db.committedVersion()
+	lastTime := time.Now()
+
+	timer := time.NewTimer(conf.Interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		version, now := db.committedVersion(), time.Now()
+		writeRate := float64(version-lastVersion) / now.Sub(lastTime).Seconds()
+		lastVersion, lastTime = version, now
+
+		if conf.BusyWriteRate > 0 && writeRate > conf.BusyWriteRate {
+			backoff = min(backoff*2, maxGCBackoff)
+			db.Logger.Debug("skip value log gc, store is busy",
+				zap.Float64("write_rate", writeRate),
+				zap.Duration("next", conf.Interval*time.Duration(backoff)))
+			timer.Reset(conf.Interval * time.Duration(backoff))
+			continue
+		}
+		backoff = 1
+
+		start := time.Now()
+		lsmBefore, vlogBefore := db.DB.Size()
+		rewrites, err := db.CollectGarbage(conf.DiscardRatio)
+		db.stats.gcRuns.Add(1)
+		db.stats.gcRewrites.Add(int64(rewrites))
+		lsmAfter, vlogAfter := db.DB.Size()
+		fields := []zap.Field{
+			zap.Int("rewrites", rewrites),
+			zap.Duration("duration", time.Since(start)),
+			zap.Int64("lsm_size_before", lsmBefore),
+			zap.Int64("lsm_size_after", lsmAfter),
+			zap.Int64("vlog_size_before", vlogBefore),
+			zap.Int64("vlog_size_after", vlogAfter),
+		}
+		if err != nil && !errors.Is(err, badger.ErrRejected) {
+			db.Logger.Error("value log gc failed", append(fields, zap.Error(err))...)
+		} else {
+			db.Logger.Info("value log gc finished", fields...)
+		}
+
+		timer.Reset(conf.Interval)
+	}
+}
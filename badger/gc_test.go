@@ -0,0 +1,119 @@
+package badger
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// newGCApp opens a store in dir whose values go to value logs, logging to
+// core.
+func newGCApp(t *testing.T, dir string, core zapcore.Core, gc gcConfig) *BadgerApp {
+	return newDiskApp(t, dir, func(app *BadgerApp) {
+		app.config.ValueLogFileSize = 4 << 20
+		app.config.GC = gc
+		app.SetLogger(zap.New(core))
+	})
+}
+
+func TestGC(t *testing.T) {
+	Convey("CollectGarbage", t, func() {
+		core, _ := observer.New(zapcore.InfoLevel)
+		app := newGCApp(t, t.TempDir(), core, gcConfig{})
+		defer app.Close(t.Context())
+
+		rewrites, err := app.CollectGarbage(0.5)
+		So(err, ShouldBeNil)
+		So(rewrites, ShouldEqual, 0)
+
+		value := bytes.Repeat([]byte("x"), 3<<19)
+		for i := range 4 {
+			So(app.Update(func(txn *badger.Txn) error {
+				return txn.Set([]byte(fmt.Sprint(i)), value)
+			}), ShouldBeNil)
+		}
+		// dropping keys records the value log space they held as discardable
+		So(app.DB.DropPrefix([]byte("0"), []byte("1"), []byte("2")), ShouldBeNil)
+		rewrites, err = app.CollectGarbage(0.5)
+		So(err, ShouldBeNil)
+		So(rewrites, ShouldBeGreaterThan, 0)
+		rewrites, err = app.CollectGarbage(0.5)
+		So(err, ShouldBeNil)
+		So(rewrites, ShouldEqual, 0)
+
+		_, err = newMemoryApp(t).CollectGarbage(0.5)
+		So(err, ShouldEqual, badger.ErrGCInMemoryMode)
+	})
+
+	Convey("The gc loop", t, func() {
+		core, logs := observer.New(zapcore.DebugLevel)
+		app := newGCApp(t, t.TempDir(), core, gcConfig{Interval: 20 * time.Millisecond, DiscardRatio: 0.5})
+		defer app.Close(t.Context())
+
+		So(waitFor(5*time.Second, func() bool { return app.stats.gcRuns.Load() >= 2 }), ShouldBeTrue)
+		finished := logs.FilterMessage("value log gc finished").All()
+		So(finished, ShouldNotBeEmpty)
+		fields := finished[0].ContextMap()
+		So(fields["rewrites"], ShouldEqual, 0)
+		for _, name := range []string{"duration", "lsm_size_before", "lsm_size_after", "vlog_size_before", "vlog_size_after"} {
+			So(fields, ShouldContainKey, name)
+		}
+		So(logs.FilterMessage("skip value log gc, store is busy").All(), ShouldBeEmpty)
+	})
+
+	Convey("The gc loop backs off while the store is busy", t, func() {
+		core, logs := observer.New(zapcore.DebugLevel)
+		interval := 20 * time.Millisecond
+		app := newGCApp(t, t.TempDir(), core, gcConfig{Interval: interval, DiscardRatio: 0.5, BusyWriteRate: 50})
+		defer app.Close(t.Context())
+
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				_ = set(app, fmt.Sprint(i%100), "value")
+			}
+		}()
+
+		skipped := func() []observer.LoggedEntry {
+			return logs.FilterMessage("skip value log gc, store is busy").All()
+		}
+		ok := waitFor(5*time.Second, func() bool {
+			entries := skipped()
+			return len(entries) > 0 && entries[len(entries)-1].ContextMap()["next"] == maxGCBackoff*interval
+		})
+		runs := app.stats.gcRuns.Load()
+		// the interval doubles up to the bound while the writes go on
+		time.Sleep(3 * maxGCBackoff * interval)
+		close(stop)
+		wg.Wait()
+		So(ok, ShouldBeTrue)
+		So(app.stats.gcRuns.Load(), ShouldEqual, runs)
+
+		var next []time.Duration
+		for _, e := range skipped() {
+			So(e.ContextMap()["write_rate"], ShouldBeGreaterThan, 50)
+			next = append(next, e.ContextMap()["next"].(time.Duration))
+		}
+		So(next[:3], ShouldResemble, []time.Duration{2 * interval, 4 * interval, 8 * interval})
+		So(next[len(next)-1], ShouldEqual, maxGCBackoff*interval)
+
+		// the store runs gc again once it is idle
+		So(waitFor(5*time.Second, func() bool { return app.stats.gcRuns.Load() > runs }), ShouldBeTrue)
+	})
+}
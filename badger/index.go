@@ -0,0 +1,313 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// indexBatchSize is the number of records indexed per transaction by Rebuild.
+const indexBatchSize = 1000
+
+// Index is a secondary index over the records of a Store. Its entries are
+// written and removed in the same transaction as the records.
+type Index[K, V any] struct {
+	store   *Store[K, V]
+	name    string
+	prefix  []byte
+	extract func(value V) [][]byte
+}
+
+// NewIndex declares the index called name on store. extract returns the
+// values a record is indexed under, any number of them. Indexes must be
+// declared before the store is written to, records written earlier are only
+// indexed by Rebuild. Indexes of stores created on a *BadgerApp are
+// registered on it under namespace/name, for the rebuild-index admin command.
+func NewIndex[K, V any](store *Store[K, V], name string, extract func(value V) [][]byte) *Index[K, V] {
+	if !validName(name) {
+		panic(fmt.Errorf("invalid index name %q", name))
+	}
+	idx := &Index[K, V]{
+		store:   store,
+		name:    name,
+		prefix:  append(reservedKey("index", string(store.namespace[:len(store.namespace)-1]), name), 0),
+		extract: extract,
+	}
+	store.indexes = append(store.indexes, idx)
+	if app, ok := store.db.(*BadgerApp); ok {
+		app.registerIndex(idx.fullName(), idx.Rebuild)
+	}
+	return idx
+}
+
+// fullName is the name of the index prefixed by the namespace of its store.
+func (idx *Index[K, V]) fullName() string {
+	return string(idx.store.namespace[:len(idx.store.namespace)-1]) + "/" + idx.name
+}
+
+// Lookup returns the records indexed under value.
+func (idx *Index[K, V]) Lookup(value []byte) ([]Entry[K, V], error) {
+	return idx.list(append(escapeIndexValue(idx.prefix, value), 0x00, 0x01))
+}
+
+// LookupPrefix returns the records indexed under a value starting with
+// prefix, in index value order.
+func (idx *Index[K, V]) LookupPrefix(prefix []byte) ([]Entry[K, V], error) {
+	return idx.list(escapeIndexValue(idx.prefix, prefix))
+}
+
+// Rebuild drops every entry of the index and indexes the records of the store
+// again, in batches. Lookups miss records while it runs.
+func (idx *Index[K, V]) Rebuild(ctx context.Context) error {
+	if err := idx.drop(ctx); err != nil {
+		return err
+	}
+
+	var last []byte
+	for {
+		done := false
+		var next []byte
+		err := idx.store.db.Update(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = idx.store.namespace
+			it := txn.NewIterator(opts)
+			defer it.Close()
+
+			it.Rewind()
+			if last != nil {
+				it.Seek(last)
+				if it.Valid() && bytes.Equal(it.Item().Key(), last) {
+					it.Next()
+				}
+			}
+			next = last
+			for n := 0; it.Valid(); it.Next() {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if n == indexBatchSize {
+					return nil
+				}
+				item := it.Item()
+				var value V
+				err := item.Value(func(val []byte) (err error) {
+					value, err = idx.store.codec.Unmarshal(val)
+					return err
+				})
+				if err != nil {
+					return err
+				}
+				key := item.KeyCopy(nil)
+				if err := idx.put(txn, key[len(idx.store.namespace):], nil, value, item.ExpiresAt()); err != nil {
+					return err
+				}
+				next = key
+				n++
+			}
+			done = true
+			return nil
+		})
+		if err != nil || done {
+			return err
+		}
+		last = next
+	}
+}
+
+func (idx *Index[K, V]) list(prefix []byte) ([]Entry[K, V], error) {
+	var entries []Entry[K, V]
+	err := idx.store.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			key, err := idx.primaryKey(it.Item().Key())
+			if err != nil {
+				return err
+			}
+			item, err := txn.Get(append(bytes.Clone(idx.store.namespace), key...))
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				// the record expired before its index entry
+				continue
+			} else if err != nil {
+				return err
+			}
+			e, err := idx.store.decodeItem(item)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// put replaces the entries of the record stored under key, given without the
+// store namespace, from its old value to its new one. A nil old value means
+// the record was not indexed before.
+func (idx *Index[K, V]) put(txn *badger.Txn, key []byte, old *V, value V, expiresAt uint64) error {
+	keep := map[string]bool{}
+	for _, v := range idx.extract(value) {
+		entryKey := idx.entryKey(v, key)
+		keep[string(entryKey)] = true
+		entry := badger.NewEntry(entryKey, nil)
+		entry.ExpiresAt = expiresAt
+		if err := txn.SetEntry(entry); err != nil {
+			return err
+		}
+	}
+	if old == nil {
+		return nil
+	}
+	for _, v := range idx.extract(*old) {
+		if entryKey := idx.entryKey(v, key); !keep[string(entryKey)] {
+			if err := txn.Delete(entryKey); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// remove deletes the entries of the record stored under key.
+func (idx *Index[K, V]) remove(txn *badger.Txn, key []byte, old V) error {
+	for _, v := range idx.extract(old) {
+		if err := txn.Delete(idx.entryKey(v, key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drop deletes every entry of the index, in batches.
+func (idx *Index[K, V]) drop(ctx context.Context) error {
+	for {
+		var keys [][]byte
+		err := idx.store.db.View(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.PrefetchValues = false
+			opts.Prefix = idx.prefix
+			it := txn.NewIterator(opts)
+			defer it.Close()
+			for it.Rewind(); it.Valid() && len(keys) < indexBatchSize; it.Next() {
+				keys = append(keys, it.Item().KeyCopy(nil))
+			}
+			return nil
+		})
+		if err != nil || len(keys) == 0 {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := idx.store.db.Update(func(txn *badger.Txn) error {
+			for _, key := range keys {
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+// entryKey is the index prefix, the escaped index value, a terminator and the
+// primary key. Escaping keeps both the order of index values and the
+// terminator unambiguous.
+func (idx *Index[K, V]) entryKey(value, key []byte) []byte {
+	entryKey := append(escapeIndexValue(idx.prefix, value), 0x00, 0x01)
+	return append(entryKey, key...)
+}
+
+func (idx *Index[K, V]) primaryKey(entryKey []byte) ([]byte, error) {
+	rest := entryKey[len(idx.prefix):]
+	for i := 0; i+1 < len(rest); i++ {
+		if rest[i] != 0x00 {
+			continue
+		}
+		if rest[i+1] == 0x01 {
+			return rest[i+2:], nil
+		}
+		i++
+	}
+	return nil, fmt.Errorf("invalid entry of index %s", idx.name)
+}
+
+// escapeIndexValue appends value to dst with every 0x00 byte followed by
+// 0xff.
+func escapeIndexValue(dst, value []byte) []byte {
+	dst = bytes.Clone(dst)
+	for _, b := range value {
+		dst = append(dst, b)
+		if b == 0x00 {
+			dst = append(dst, 0xff)
+		}
+	}
+	return dst
+}
+
+// registeredIndex is an index declared on a store of the app.
+type registeredIndex struct {
+	name    string
+	rebuild func(ctx context.Context) error
+}
+
+// registerIndex records the index called name, an index declared again
+// replaces the earlier one.
+func (db *BadgerApp) registerIndex(name string, rebuild func(ctx context.Context) error) {
+	db.indexesMu.Lock()
+	defer db.indexesMu.Unlock()
+	for i, idx := range db.indexes {
+		if idx.name == name {
+			db.indexes[i].rebuild = rebuild
+			return
+		}
+	}
+	db.indexes = append(db.indexes, registeredIndex{name, rebuild})
+}
+
+// RebuildIndexes rebuilds the named indexes of the app, every registered one
+// when no name is given, in the order they were declared. Names are the
+// namespace of the store and the name of the index joined by a slash.
+func (db *BadgerApp) RebuildIndexes(ctx context.Context, names ...string) ([]string, error) {
+	db.indexesMu.Lock()
+	indexes := slices.Clone(db.indexes)
+	db.indexesMu.Unlock()
+
+	if len(names) > 0 {
+		selected := make([]registeredIndex, 0, len(names))
+		for _, name := range names {
+			i := slices.IndexFunc(indexes, func(idx registeredIndex) bool { return idx.name == name })
+			if i < 0 {
+				return nil, fmt.Errorf("unknown index %q", name)
+			}
+			selected = append(selected, indexes[i])
+		}
+		indexes = selected
+	}
+
+	rebuilt := make([]string, 0, len(indexes))
+	for _, idx := range indexes {
+		if err := idx.rebuild(ctx); err != nil {
+			return rebuilt, fmt.Errorf("rebuild index %s: %w", idx.name, err)
+		}
+		rebuilt = append(rebuilt, idx.name)
+	}
+	return rebuilt, nil
+}
+
+// storeIndex is implemented by the indexes of a Store, whatever their types.
+type storeIndex[V any] interface {
+	put(txn *badger.Txn, key []byte, old *V, value V, expiresAt uint64) error
+	remove(txn *badger.Txn, key []byte, old V) error
+}
@@ -0,0 +1,102 @@
+package badger
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type indexedAsset struct {
+	IP      string
+	Port    uint16
+	Domains []string
+}
+
+func TestIndex(t *testing.T) {
+	app := newMemoryApp(t)
+
+	byIP := func(a indexedAsset) [][]byte { return [][]byte{[]byte(a.IP)} }
+	byPort := func(a indexedAsset) [][]byte { return [][]byte{binary.BigEndian.AppendUint16(nil, a.Port)} }
+	byDomain := func(a indexedAsset) [][]byte {
+		var values [][]byte
+		for _, d := range a.Domains {
+			values = append(values, []byte(d))
+		}
+		return values
+	}
+	keys := func(entries []Entry[string, indexedAsset]) []string {
+		var keys []string
+		for _, e := range entries {
+			keys = append(keys, e.Key)
+		}
+		return keys
+	}
+
+	Convey("Index", t, func() {
+		assets := NewStore[string, indexedAsset](app, "indexed", StringKey{}, JSONCodec[indexedAsset]{})
+		ips := NewIndex(assets, "ip", byIP)
+		ports := NewIndex(assets, "port", byPort)
+		domains := NewIndex(assets, "domain", byDomain)
+
+		So(assets.Put("a", indexedAsset{"10.0.0.1", 443, []string{"a.example.com", "www.example.com"}}), ShouldBeNil)
+		So(assets.Put("b", indexedAsset{"10.0.0.2", 443, []string{"b.example.org"}}), ShouldBeNil)
+		So(assets.Put("c", indexedAsset{"10.0.0.1", 80, nil}), ShouldBeNil)
+
+		found, err := ips.Lookup([]byte("10.0.0.1"))
+		So(err, ShouldBeNil)
+		So(keys(found), ShouldResemble, []string{"a", "c"})
+		So(found[0].Value.Port, ShouldEqual, 443)
+
+		found, err = ports.Lookup(binary.BigEndian.AppendUint16(nil, 443))
+		So(err, ShouldBeNil)
+		So(keys(found), ShouldResemble, []string{"a", "b"})
+
+		found, err = domains.LookupPrefix([]byte("b."))
+		So(err, ShouldBeNil)
+		So(keys(found), ShouldResemble, []string{"b"})
+
+		// updates move the entries
+		So(assets.Put("a", indexedAsset{"10.0.0.3", 443, []string{"www.example.com"}}), ShouldBeNil)
+		found, err = ips.Lookup([]byte("10.0.0.1"))
+		So(err, ShouldBeNil)
+		So(keys(found), ShouldResemble, []string{"c"})
+		found, err = domains.Lookup([]byte("a.example.com"))
+		So(err, ShouldBeNil)
+		So(found, ShouldBeEmpty)
+
+		So(assets.Delete("c"), ShouldBeNil)
+		found, err = ips.LookupPrefix([]byte("10.0.0."))
+		So(err, ShouldBeNil)
+		So(keys(found), ShouldResemble, []string{"b", "a"})
+
+		// values sharing a prefix with a 0x00 byte stay apart
+		So(assets.Put("d", indexedAsset{IP: "10.0.0.4\x00"}), ShouldBeNil)
+		So(assets.Put("e", indexedAsset{IP: "10.0.0.4"}), ShouldBeNil)
+		found, err = ips.Lookup([]byte("10.0.0.4"))
+		So(err, ShouldBeNil)
+		So(keys(found), ShouldResemble, []string{"e"})
+		found, err = ips.LookupPrefix([]byte("10.0.0.4"))
+		So(err, ShouldBeNil)
+		So(keys(found), ShouldHaveLength, 2)
+	})
+
+	Convey("Rebuild", t, func() {
+		plain := NewStore[string, indexedAsset](app, "rebuilt", StringKey{}, JSONCodec[indexedAsset]{})
+		for i := range 2500 {
+			So(plain.Put(fmt.Sprintf("%04d", i), indexedAsset{IP: fmt.Sprintf("10.0.%d.%d", i%2, i)}), ShouldBeNil)
+		}
+
+		assets := NewStore[string, indexedAsset](app, "rebuilt", StringKey{}, JSONCodec[indexedAsset]{})
+		ips := NewIndex(assets, "ip", byIP)
+		found, err := ips.LookupPrefix([]byte("10.0.1."))
+		So(err, ShouldBeNil)
+		So(found, ShouldBeEmpty)
+
+		So(ips.Rebuild(t.Context()), ShouldBeNil)
+		found, err = ips.LookupPrefix([]byte("10.0.1."))
+		So(err, ShouldBeNil)
+		So(found, ShouldHaveLength, 1250)
+	})
+}
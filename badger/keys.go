@@ -0,0 +1,24 @@
+package badger
+
+import (
+	"bytes"
+	"strings"
+)
+
+// reservedPrefix starts the keys the app keeps for its own bookkeeping. Store
+// namespaces never contain 0x00, so they cannot collide with it.
+var reservedPrefix = []byte("\x00go-app\x00")
+
+func reservedKey(parts ...string) []byte {
+	return append(bytes.Clone(reservedPrefix), strings.Join(parts, "\x00")...)
+}
+
+// validName reports whether name can be a part of a reserved key, it has to
+// be set and cannot contain the 0x00 separating the parts.
+func validName(name string) bool {
+	return name != "" && strings.IndexByte(name, 0) < 0
+}
+
+func isReservedKey(key []byte) bool {
+	return bytes.HasPrefix(key, reservedPrefix)
+}
@@ -1,17 +1,208 @@
 package badger
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/dgraph-io/badger/v4"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 var _ badger.Logger = &logger{}
 
+// logger bridges the badger log to zap. The messages of compactions, memtable
+// flushes and value log GC get a fixed message with their values as fields,
+// so the entries group and query well in the log backends, the others are
+// logged formatted.
 type logger struct {
-	*zap.SugaredLogger
+	l     *zap.Logger
+	level zapcore.Level
+	// stats counts the compactions of the store, at every level
+	stats *stats
+}
+
+const (
+	compactionDoneFormat   = "[Compactor: %d] Compaction for level: %d DONE"
+	compactionFailedFormat = "[Compactor: %d] LOG Compact FAILED with error: %+v: %+v"
+)
+
+func newLogger(l *zap.Logger, level zapcore.Level) *logger {
+	// skip the bridge and badger's Options wrapper to report the badger
+	// caller
+	return &logger{l: l.WithOptions(zap.AddCallerSkip(3)), level: level}
+}
+
+// badgerLogger returns the bridge of the badger log to the logger of the app,
+// and through it to the log cores the application registered.
+func (db *BadgerApp) badgerLogger() *logger {
+	level, err := parseLogLevel(db.config.LogLevel)
+	if err != nil {
+		// rejected by options already
+		level = zapcore.InfoLevel
+	}
+	l := newLogger(db.Logger, level)
+	l.stats = &db.stats
+	return l
+}
+
+// parseLogLevel parses the minimum level of badger log entries, warning is
+// accepted as badger names it.
+func parseLogLevel(s string) (zapcore.Level, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return zapcore.InfoLevel, nil
+	case "warning":
+		return zapcore.WarnLevel, nil
+	}
+	level, err := zapcore.ParseLevel(s)
+	if err != nil || level > zapcore.ErrorLevel {
+		return 0, fmt.Errorf("unknown badger log level %q, expected one of debug, info, warning, error", s)
+	}
+	return level, nil
+}
+
+// Errorf implements badger.Logger.
+func (l *logger) Errorf(format string, args ...interface{}) {
+	l.log(zapcore.ErrorLevel, format, args)
 }
 
 // Warningf implements badger.Logger.
-func (l *logger) Warningf(msg string, args ...interface{}) {
-	l.Warnf(msg, args...)
+func (l *logger) Warningf(format string, args ...interface{}) {
+	l.log(zapcore.WarnLevel, format, args)
+}
+
+// Infof implements badger.Logger.
+func (l *logger) Infof(format string, args ...interface{}) {
+	l.log(zapcore.InfoLevel, format, args)
+}
+
+// Debugf implements badger.Logger.
+func (l *logger) Debugf(format string, args ...interface{}) {
+	l.log(zapcore.DebugLevel, format, args)
+}
+
+func (l *logger) log(level zapcore.Level, format string, args []interface{}) {
+	if l.stats != nil {
+		switch format {
+		case compactionDoneFormat:
+			l.stats.compactions.Add(1)
+		case compactionFailedFormat:
+			l.stats.compactionFailures.Add(1)
+		}
+	}
+	if level < l.level || !l.l.Core().Enabled(level) {
+		return
+	}
+	var msg string
+	var fields []zap.Field
+	if p, ok := logPatterns[format]; !ok || len(p.fields) != len(args) {
+		msg = strings.TrimSpace(fmt.Sprintf(format, args...))
+	} else {
+		msg = p.message
+		fields = append(fields, zap.String("event", p.event))
+		for i, name := range p.fields {
+			if name != "" {
+				fields = append(fields, logField(name, args[i]))
+			}
+		}
+	}
+	if ce := l.l.Check(level, msg); ce != nil {
+		ce.Write(fields...)
+	}
+}
+
+// logField keeps the values zap encodes natively typed and formats the
+// others like badger does.
+func logField(name string, arg interface{}) zap.Field {
+	switch v := arg.(type) {
+	case error:
+		return zap.NamedError(name, v)
+	case time.Duration, string, bool, int, int32, int64, uint32, uint64, float64:
+		return zap.Any(name, v)
+	default:
+		return zap.String(name, fmt.Sprintf("%+v", v))
+	}
+}
+
+// logPattern describes a badger log format, fields name its arguments in
+// order, an empty name drops the argument.
+type logPattern struct {
+	event   string
+	message string
+	fields  []string
+}
+
+// logPatterns are the known badger log formats by format string, matching
+// badger v4.
+var logPatterns = map[string]logPattern{
+	"[%d]%s LOG Compact %d->%d (%d, %d -> %d tables with %d splits). [%s] -> [%s], took %v\n, deleted %d bytes": {
+		"compaction", "badger compaction done",
+		[]string{"compactor", "", "from_level", "to_level", "top_tables", "bottom_tables", "new_tables", "splits", "from_tables", "to_tables", "duration", "deleted_bytes"},
+	},
+	"[%d] LOG Compact. Added %d keys. Skipped %d keys. Iteration took: %v": {
+		"compaction", "badger compaction iterated keys",
+		[]string{"compactor", "added_keys", "skipped_keys", "duration"},
+	},
+	compactionFailedFormat: {
+		"compaction", "badger compaction failed",
+		[]string{"compactor", "error", "compaction"},
+	},
+	compactionDoneFormat: {
+		"compaction", "badger compaction of level done",
+		[]string{"compactor", "level"},
+	},
+	"While running doCompact: %v\n": {
+		"compaction", "badger compaction failed",
+		[]string{"error"},
+	},
+	"While compacting level 0: %v": {
+		"compaction", "badger compaction of level 0 failed",
+		[]string{"error"},
+	},
+	"L0 was stalled for %s\n": {
+		"compaction", "badger writes stalled on level 0",
+		[]string{"duration"},
+	},
+	"Flushing memtable": {
+		"flush", "badger flushing memtable",
+		nil,
+	},
+	"Flushing memtable, mt.size=%d size of flushChan: %d\n": {
+		"flush", "badger flushing memtable",
+		[]string{"memtable_size", "flush_queue"},
+	},
+	"error flushing memtable to disk: %v, retrying": {
+		"flush", "badger memtable flush failed, retrying",
+		[]string{"error"},
+	},
+	"While trying to flush memtable: %v": {
+		"flush", "badger memtable flush failed",
+		[]string{"error"},
+	},
+	"Found value log max discard fid: %d discard: %d\n": {
+		"gc", "badger value log gc picked file",
+		[]string{"fid", "discard_bytes"},
+	},
+	"Discard: %d less than threshold: %.0f for file: %s": {
+		"gc", "badger value log gc skipped file below discard threshold",
+		[]string{"discard_bytes", "threshold_bytes", "file"},
+	},
+	"Rewriting fid: %d": {
+		"gc", "badger value log gc rewriting file",
+		[]string{"fid"},
+	},
+	"Processed %d entries in %d loops": {
+		"gc", "badger value log gc processed entries",
+		[]string{"entries", "loops"},
+	},
+	"Total entries: %d. Moved: %d": {
+		"gc", "badger value log gc moved entries",
+		[]string{"entries", "moved"},
+	},
+	"Removing fid: %d": {
+		"gc", "badger value log gc removing file",
+		[]string{"fid"},
+	},
 }
@@ -0,0 +1,83 @@
+package badger
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogger(t *testing.T) {
+	Convey("Badger log bridge", t, func() {
+		core, logs := observer.New(zapcore.DebugLevel)
+		l := newLogger(zap.New(core), zapcore.DebugLevel)
+
+		l.Infof("Rewriting fid: %d", uint32(3))
+		l.Warningf("[Compactor: %d] LOG Compact FAILED with error: %+v: %+v", 1, errors.New("disk full"), struct{ Level int }{2})
+		l.Infof("Set nextTxnTs to %d\n", 42)
+
+		entries := logs.TakeAll()
+		So(entries, ShouldHaveLength, 3)
+
+		So(entries[0].Message, ShouldEqual, "badger value log gc rewriting file")
+		So(entries[0].ContextMap(), ShouldResemble, map[string]any{"event": "gc", "fid": uint32(3)})
+
+		So(entries[1].Level, ShouldEqual, zapcore.WarnLevel)
+		So(entries[1].Message, ShouldEqual, "badger compaction failed")
+		fields := entries[1].ContextMap()
+		So(fields["event"], ShouldEqual, "compaction")
+		So(fields["compactor"], ShouldEqual, int64(1))
+		So(fields["error"], ShouldEqual, "disk full")
+		So(fields["compaction"], ShouldEqual, "{Level:2}")
+		So(entries[1].Context[2].Type, ShouldEqual, zapcore.ErrorType)
+
+		So(entries[2].Message, ShouldEqual, "Set nextTxnTs to 42")
+		So(entries[2].Context, ShouldBeEmpty)
+
+		Convey("drops entries below the minimum level", func() {
+			l := newLogger(zap.New(core), zapcore.WarnLevel)
+			l.Debugf("Flushing memtable")
+			l.Infof("Removing fid: %d", uint32(1))
+			l.Errorf("writeRequests: %v", errors.New("closed"))
+			entries := logs.TakeAll()
+			So(entries, ShouldHaveLength, 1)
+			So(entries[0].Message, ShouldEqual, "writeRequests: closed")
+		})
+
+		Convey("parses levels", func() {
+			for s, level := range map[string]zapcore.Level{
+				"":        zapcore.InfoLevel,
+				"debug":   zapcore.DebugLevel,
+				"WARNING": zapcore.WarnLevel,
+				"warn":    zapcore.WarnLevel,
+				"error":   zapcore.ErrorLevel,
+			} {
+				parsed, err := parseLogLevel(s)
+				So(err, ShouldBeNil)
+				So(parsed, ShouldEqual, level)
+			}
+			_, err := parseLogLevel("fatal")
+			So(err, ShouldNotBeNil)
+			_, err = (&config{LogLevel: "verbose"}).options()
+			So(err.Error(), ShouldContainSubstring, "badger.log_level")
+		})
+	})
+
+	Convey("Badger logs reach the cores of the app logger", t, func() {
+		core, logs := observer.New(zapcore.DebugLevel)
+		app := newStartupApp(t.TempDir(), startupConfig{})
+		app.config.LogLevel = "debug"
+		app.SetLogger(zap.New(zapcore.NewTee(zapcore.NewNopCore(), core)))
+		So(app.initialize(t.Context()), ShouldBeNil)
+		So(set(app, "key", "value"), ShouldBeNil)
+		app.Close(t.Context())
+
+		flushes := logs.FilterMessage("badger flushing memtable").All()
+		So(flushes, ShouldNotBeEmpty)
+		So(flushes[0].ContextMap()["event"], ShouldEqual, "flush")
+		So(flushes[0].LoggerName, ShouldEqual, "Badger")
+	})
+}
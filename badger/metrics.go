@@ -0,0 +1,179 @@
+package badger
+
+import (
+	"context"
+	"expvar"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+)
+
+const (
+	ScopeName = "github.com/yoshino-s/go-app/badger"
+)
+
+// stats holds the counters the app maintains itself because badger does not
+// expose them.
+type stats struct {
+	gcRuns             atomic.Int64
+	gcRewrites         atomic.Int64
+	compactions        atomic.Int64
+	compactionFailures atomic.Int64
+	conflicts          atomic.Int64
+	retries            atomic.Int64
+}
+
+// registerMetrics registers observable instruments on the global meter
+// provider, which is set by the telemetry app when it is configured.
+func (db *BadgerApp) registerMetrics() (metric.Registration, error) {
+	meter := otel.Meter(ScopeName)
+
+	lsmSize, err := meter.Int64ObservableGauge("badger.lsm.size",
+		metric.WithUnit("By"), metric.WithDescription("Size of the LSM tree"))
+	if err != nil {
+		return nil, err
+	}
+	vlogSize, err := meter.Int64ObservableGauge("badger.vlog.size",
+		metric.WithUnit("By"), metric.WithDescription("Size of the value log"))
+	if err != nil {
+		return nil, err
+	}
+	levelTables, err := meter.Int64ObservableGauge("badger.lsm.level.tables",
+		metric.WithDescription("Number of tables per LSM level"))
+	if err != nil {
+		return nil, err
+	}
+	compactions, err := meter.Int64ObservableCounter("badger.compaction.runs",
+		metric.WithDescription("Number of LSM compactions done"))
+	if err != nil {
+		return nil, err
+	}
+	compactionFailures, err := meter.Int64ObservableCounter("badger.compaction.failures",
+		metric.WithDescription("Number of LSM compactions that failed"))
+	if err != nil {
+		return nil, err
+	}
+	cacheHitRatio, err := meter.Float64ObservableGauge("badger.cache.hit_ratio",
+		metric.WithDescription("Hit ratio of the block and index caches"))
+	if err != nil {
+		return nil, err
+	}
+	pendingWrites, err := meter.Int64ObservableGauge("badger.writes.pending",
+		metric.WithDescription("Number of writes waiting to be applied to the memtable"))
+	if err != nil {
+		return nil, err
+	}
+	gcRuns, err := meter.Int64ObservableCounter("badger.gc.runs",
+		metric.WithDescription("Number of value log gc passes"))
+	if err != nil {
+		return nil, err
+	}
+	gcRewrites, err := meter.Int64ObservableCounter("badger.gc.rewrites",
+		metric.WithDescription("Number of value log files rewritten by gc"))
+	if err != nil {
+		return nil, err
+	}
+	conflicts, err := meter.Int64ObservableCounter("badger.txn.conflicts",
+		metric.WithDescription("Number of transactions aborted by a conflict"))
+	if err != nil {
+		return nil, err
+	}
+
+	retries, err := meter.Int64ObservableCounter("badger.txn.retries",
+		metric.WithDescription("Number of updates retried after a conflict"))
+	if err != nil {
+		return nil, err
+	}
+
+	replicationLag, err := meter.Float64ObservableGauge("badger.replication.lag",
+		metric.WithUnit("s"), metric.WithDescription("Time since the last heartbeat of the primary applied by a follower"))
+	if err != nil {
+		return nil, err
+	}
+
+	quotaExceeded, err := meter.Int64ObservableGauge("badger.quota.exceeded",
+		metric.WithDescription("Whether the soft or hard limit of the storage quota is exceeded"))
+	if err != nil {
+		return nil, err
+	}
+	freeSpace, err := meter.Int64ObservableGauge("badger.fs.free",
+		metric.WithUnit("By"), metric.WithDescription("Free space of the filesystem holding the store"))
+	if err != nil {
+		return nil, err
+	}
+
+	return meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		attrs := metric.WithAttributeSet(db.metricAttributes())
+
+		lsm, vlog := db.DB.Size()
+		o.ObserveInt64(lsmSize, lsm, attrs)
+		o.ObserveInt64(vlogSize, vlog, attrs)
+
+		for _, level := range db.DB.Levels() {
+			o.ObserveInt64(levelTables, int64(level.NumTables), attrs,
+				metric.WithAttributes(attribute.Int("level", level.Level)))
+		}
+		if m := db.DB.BlockCacheMetrics(); m != nil {
+			o.ObserveFloat64(cacheHitRatio, m.Ratio(), attrs, metric.WithAttributes(attribute.String("cache", "block")))
+		}
+		if m := db.DB.IndexCacheMetrics(); m != nil {
+			o.ObserveFloat64(cacheHitRatio, m.Ratio(), attrs, metric.WithAttributes(attribute.String("cache", "index")))
+		}
+		// badger keys the pending writes by directory, which in-memory stores
+		// share
+		if m, ok := expvar.Get("badger_write_pending_num_memtable").(*expvar.Map); ok && !db.DB.Opts().InMemory {
+			if v, ok := m.Get(db.DB.Opts().Dir).(*expvar.Int); ok {
+				o.ObserveInt64(pendingWrites, v.Value(), attrs)
+			}
+		}
+
+		o.ObserveInt64(gcRuns, db.stats.gcRuns.Load(), attrs)
+		o.ObserveInt64(gcRewrites, db.stats.gcRewrites.Load(), attrs)
+		o.ObserveInt64(compactions, db.stats.compactions.Load(), attrs)
+		o.ObserveInt64(compactionFailures, db.stats.compactionFailures.Load(), attrs)
+		o.ObserveInt64(conflicts, db.stats.conflicts.Load(), attrs)
+		o.ObserveInt64(retries, db.stats.retries.Load(), attrs)
+		if db.quotaEnabled() {
+			o.ObserveInt64(quotaExceeded, boolToInt(db.quota.soft.Load()), attrs, metric.WithAttributes(attribute.String("limit", "soft")))
+			o.ObserveInt64(quotaExceeded, boolToInt(db.quota.hard.Load() != nil), attrs, metric.WithAttributes(attribute.String("limit", "hard")))
+			if free := db.quota.free.Load(); free >= 0 {
+				o.ObserveInt64(freeSpace, free, attrs)
+			}
+		}
+		if db.config.Replication.Primary != "" {
+			o.ObserveFloat64(replicationLag, db.ReplicationStatus().Lag.Seconds(), attrs)
+		}
+		return nil
+	}, lsmSize, vlogSize, levelTables, compactions, compactionFailures, cacheHitRatio, pendingWrites, gcRuns, gcRewrites, conflicts, retries, replicationLag, quotaExceeded, freeSpace)
+}
+
+func (db *BadgerApp) metricAttributes() attribute.Set {
+	attrs := []attribute.KeyValue{attribute.String("db.system", "badger")}
+	if db.config.name != "" {
+		attrs = append(attrs, attribute.String("db.badger.instance", db.config.name))
+	}
+	if db.checkpoint != "" {
+		attrs = append(attrs, attribute.String("db.badger.checkpoint", db.checkpoint))
+	}
+	return attribute.NewSet(attrs...)
+}
+
+func (db *BadgerApp) unregisterMetrics() {
+	if db.metrics == nil {
+		return
+	}
+	if err := db.metrics.Unregister(); err != nil {
+		db.Logger.Warn("unregister badger metrics failed", zap.Error(err))
+	}
+	db.metrics = nil
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
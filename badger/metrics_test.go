@@ -0,0 +1,69 @@
+package badger
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestMetrics(t *testing.T) {
+	// registered first so it runs after the apps closed
+	provider := otel.GetMeterProvider()
+	t.Cleanup(func() { otel.SetMeterProvider(provider) })
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+
+	app := newMemoryApp(t)
+	named := New("named")
+	named.config = testConfig("")
+	named.config.name = "named"
+	named.config.InMemory = true
+	named.Initialize(t.Context())
+	t.Cleanup(func() { named.Close(t.Context()) })
+
+	Convey("Metrics", t, func() {
+		So(set(app, "key", "value"), ShouldBeNil)
+		// the compactions are counted from the badger log of each store
+		app.badgerLogger().Debugf(compactionDoneFormat, 0, 1)
+		app.badgerLogger().Warningf(compactionFailedFormat, 1, errors.New("disk full"), struct{}{})
+		named.badgerLogger().Debugf(compactionDoneFormat, 0, 1)
+		named.badgerLogger().Debugf(compactionDoneFormat, 0, 2)
+
+		var rm metricdata.ResourceMetrics
+		So(reader.Collect(t.Context(), &rm), ShouldBeNil)
+
+		metrics := map[string]metricdata.Aggregation{}
+		for _, sm := range rm.ScopeMetrics {
+			if sm.Scope.Name != ScopeName {
+				continue
+			}
+			for _, m := range sm.Metrics {
+				metrics[m.Name] = m.Data
+			}
+		}
+		So(metrics, ShouldContainKey, "badger.lsm.size")
+		So(metrics, ShouldContainKey, "badger.vlog.size")
+		So(metrics, ShouldContainKey, "badger.cache.hit_ratio")
+		So(metrics, ShouldContainKey, "badger.txn.conflicts")
+		So(metrics, ShouldNotContainKey, "badger.compaction.tables")
+
+		// sum returns the value of the counter per store instance
+		sum := func(name string) map[string]int64 {
+			values := map[string]int64{}
+			data, ok := metrics[name].(metricdata.Sum[int64])
+			So(ok, ShouldBeTrue)
+			for _, point := range data.DataPoints {
+				instance, _ := point.Attributes.Value(attribute.Key("db.badger.instance"))
+				values[instance.AsString()] = point.Value
+			}
+			return values
+		}
+		So(sum("badger.compaction.runs"), ShouldResemble, map[string]int64{"": 1, "named": 2})
+		So(sum("badger.compaction.failures"), ShouldResemble, map[string]int64{"": 1, "named": 0})
+	})
+}
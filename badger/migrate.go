@@ -0,0 +1,355 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/dgraph-io/badger/v4"
+	"go.uber.org/zap"
+)
+
+// migrationBatchSize is the number of keys rewritten per transaction.
+const migrationBatchSize = 1000
+
+// ErrDeleteKey can be returned by a RewriteFunc to delete the key.
+var ErrDeleteKey = errors.New("delete key")
+
+// MigrationFunc moves the stored data from the previous schema version to the
+// version it is registered with.
+type MigrationFunc func(ctx context.Context, m *Migration) error
+
+// RewriteFunc returns the new value of key, or ErrDeleteKey.
+type RewriteFunc func(key, value []byte) ([]byte, error)
+
+// MigrationReport describes what a migration changed, or would change in a
+// dry run. Changed and Deleted count the keys of Rewrite steps only, the
+// writes of Update steps are not reported.
+type MigrationReport struct {
+	Version int
+	Name    string
+	DryRun  bool
+	Scanned int
+	Changed int
+	Deleted int
+}
+
+type migrationConfig struct {
+	DryRun bool `mapstructure:"dry_run"`
+}
+
+type migration struct {
+	version int
+	name    string
+	fn      MigrationFunc
+}
+
+// RegisterMigration registers the migration to schema version. Migrations
+// newer than the stored version run in order during Initialize, so they have
+// to be registered before it.
+func (db *BadgerApp) RegisterMigration(version int, name string, fn MigrationFunc) {
+	if version <= 0 {
+		panic(fmt.Errorf("invalid migration version %d", version))
+	}
+	if slices.ContainsFunc(db.migrations, func(m migration) bool { return m.version == version }) {
+		panic(fmt.Errorf("migration version %d registered twice", version))
+	}
+	db.migrations = append(db.migrations, migration{version, name, fn})
+	slices.SortFunc(db.migrations, func(a, b migration) int { return a.version - b.version })
+}
+
+// SchemaVersion returns the version of the last migration applied to the
+// store, 0 when none was.
+func (db *BadgerApp) SchemaVersion() (int, error) {
+	var version int
+	err := db.DB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(reservedKey("schema-version"))
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			if len(val) != 8 {
+				return fmt.Errorf("invalid schema version")
+			}
+			version = int(binary.BigEndian.Uint64(val))
+			return nil
+		})
+	})
+	return version, err
+}
+
+// Migrate runs the registered migrations newer than the schema version. A
+// migration interrupted halfway resumes where it stopped on the next run. In a
+// dry run nothing is written and the reports tell what would change.
+func (db *BadgerApp) Migrate(ctx context.Context, dryRun bool) ([]MigrationReport, error) {
+	if len(db.migrations) == 0 {
+		return nil, nil
+	}
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if latest := db.migrations[len(db.migrations)-1].version; current > latest {
+		return nil, fmt.Errorf("badger store schema version %d is newer than the latest known migration %d", current, latest)
+	}
+
+	var reports []MigrationReport
+	for _, mig := range db.migrations {
+		if mig.version <= current {
+			continue
+		}
+
+		m := &Migration{
+			db:      db,
+			version: mig.version,
+			report:  MigrationReport{Version: mig.version, Name: mig.name, DryRun: dryRun},
+		}
+		if err := mig.fn(ctx, m); err != nil {
+			return reports, fmt.Errorf("migration %d %s: %w", mig.version, mig.name, err)
+		}
+		if !dryRun {
+			if err := m.finish(); err != nil {
+				return reports, err
+			}
+		}
+
+		reports = append(reports, m.report)
+		db.Logger.Info("badger migration finished",
+			zap.Int("version", mig.version),
+			zap.String("name", mig.name),
+			zap.Bool("dry_run", dryRun),
+			zap.Int("scanned", m.report.Scanned),
+			zap.Int("changed", m.report.Changed),
+			zap.Int("deleted", m.report.Deleted),
+		)
+	}
+	return reports, nil
+}
+
+// runMigrations migrates the store during Initialize.
+func (db *BadgerApp) runMigrations(ctx context.Context) error {
+	if len(db.migrations) == 0 {
+		return nil
+	}
+	if db.DB.Opts().ReadOnly && !db.config.Migrations.DryRun {
+		db.Logger.Warn("badger store is read-only, migrations are not run")
+		return nil
+	}
+	_, err := db.Migrate(ctx, db.config.Migrations.DryRun)
+	return err
+}
+
+// Migration is handed to a MigrationFunc. Every Rewrite and Update call is a
+// step whose completion is persisted, so a resumed migration skips the steps
+// it already finished. Steps are told apart by the order they are called in.
+type Migration struct {
+	db      *BadgerApp
+	version int
+	step    int
+	report  MigrationReport
+}
+
+// DryRun reports whether the migration must not write anything.
+func (m *Migration) DryRun() bool {
+	return m.report.DryRun
+}
+
+// Rewrite calls fn for every key under prefix and stores the value it returns
+// when it differs, keeping the expiry and user meta of the key. Keys are
+// rewritten in batches, each committed along with the last key it covered, so
+// interrupted rewrites resume after it.
+func (m *Migration) Rewrite(ctx context.Context, prefix []byte, fn RewriteFunc) error {
+	m.step++
+	stepKey := m.stepKey()
+	done, last, err := m.loadStep(stepKey)
+	if err != nil || done {
+		return err
+	}
+
+	for {
+		var next []byte
+		var report MigrationReport
+		update := m.db.DB.Update
+		if m.DryRun() {
+			update = m.db.DB.View
+		}
+		err := update(func(txn *badger.Txn) error {
+			var err error
+			next, report, err = m.rewriteBatch(ctx, txn, prefix, last, migrationBatchSize, fn)
+			if err != nil || m.DryRun() {
+				return err
+			}
+			if next == nil {
+				return txn.Set(stepKey, []byte{stepDone})
+			}
+			return txn.Set(stepKey, append([]byte{stepRunning}, next...))
+		})
+		if err != nil {
+			return err
+		}
+
+		m.report.Scanned += report.Scanned
+		m.report.Changed += report.Changed
+		m.report.Deleted += report.Deleted
+		if next == nil {
+			return nil
+		}
+		last = next
+	}
+}
+
+// Update runs fn in a single transaction committed along with the step. In a
+// dry run fn runs but the transaction is discarded. Its writes are not counted
+// in the report, a dry run of a migration made of Update steps reports no
+// change, use Rewrite for the changes a dry run has to show.
+func (m *Migration) Update(fn func(txn *badger.Txn) error) error {
+	m.step++
+	stepKey := m.stepKey()
+	done, _, err := m.loadStep(stepKey)
+	if err != nil || done {
+		return err
+	}
+
+	if m.DryRun() {
+		txn := m.db.DB.NewTransaction(true)
+		defer txn.Discard()
+		return fn(txn)
+	}
+	return m.db.DB.Update(func(txn *badger.Txn) error {
+		if err := fn(txn); err != nil {
+			return err
+		}
+		return txn.Set(stepKey, []byte{stepDone})
+	})
+}
+
+// rewriteBatch rewrites up to limit keys after last and returns the last key
+// it covered when keys remain. In a dry run it only counts the changes.
+func (m *Migration) rewriteBatch(ctx context.Context, txn *badger.Txn, prefix, last []byte, limit int, fn RewriteFunc) (next []byte, report MigrationReport, err error) {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	it.Rewind()
+	if last != nil {
+		it.Seek(last)
+		if it.Valid() && bytes.Equal(it.Item().Key(), last) {
+			it.Next()
+		}
+	}
+
+	for ; it.Valid(); it.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, report, err
+		}
+		if report.Scanned == limit {
+			return last, report, nil
+		}
+
+		item := it.Item()
+		if isReservedKey(item.Key()) {
+			continue
+		}
+		key := item.KeyCopy(nil)
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, report, err
+		}
+
+		newValue, err := fn(key, value)
+		deleted := errors.Is(err, ErrDeleteKey)
+		if err != nil && !deleted {
+			return nil, report, err
+		}
+		changed := !deleted && !bytes.Equal(newValue, value)
+
+		if !m.DryRun() {
+			var err error
+			if deleted {
+				err = txn.Delete(key)
+			} else if changed {
+				entry := badger.NewEntry(key, newValue).WithMeta(item.UserMeta())
+				entry.ExpiresAt = item.ExpiresAt()
+				err = txn.SetEntry(entry)
+			}
+			if errors.Is(err, badger.ErrTxnTooBig) && report.Scanned > 0 {
+				// the key is picked up again by the next batch
+				return last, report, nil
+			} else if err != nil {
+				return nil, report, err
+			}
+		}
+
+		report.Scanned++
+		if deleted {
+			report.Deleted++
+		} else if changed {
+			report.Changed++
+		}
+		last = key
+	}
+	return nil, report, nil
+}
+
+// finish stores the version of the migration and drops its step progress.
+func (m *Migration) finish() error {
+	prefix := m.stepPrefix()
+	return m.db.DB.Update(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		var keys [][]byte
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		it.Close()
+
+		for _, key := range keys {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		return txn.Set(reservedKey("schema-version"), binary.BigEndian.AppendUint64(nil, uint64(m.version)))
+	})
+}
+
+const (
+	stepRunning byte = iota
+	stepDone
+)
+
+func (m *Migration) stepPrefix() []byte {
+	return append(reservedKey("migration", strconv.Itoa(m.version)), 0)
+}
+
+func (m *Migration) stepKey() []byte {
+	return append(m.stepPrefix(), strconv.Itoa(m.step)...)
+}
+
+// loadStep returns whether the step is done, or the last key it covered.
+func (m *Migration) loadStep(key []byte) (done bool, last []byte, err error) {
+	err = m.db.DB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			if len(val) == 0 {
+				return fmt.Errorf("invalid progress of migration %d", m.version)
+			}
+			done, last = val[0] == stepDone, bytes.Clone(val[1:])
+			return nil
+		})
+	})
+	return done, last, err
+}
@@ -0,0 +1,118 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMigrate(t *testing.T) {
+	app := newMemoryApp(t)
+	ctx := t.Context()
+
+	const keys = 2500
+	calls, failAt := 0, 0
+	app.RegisterMigration(1, "v2 values", func(ctx context.Context, m *Migration) error {
+		return m.Rewrite(ctx, []byte("m/"), func(key, value []byte) ([]byte, error) {
+			calls++
+			if calls == failAt {
+				return nil, errors.New("interrupted")
+			}
+			if bytes.Equal(key, []byte("m/drop")) {
+				return nil, ErrDeleteKey
+			}
+			return []byte("v2"), nil
+		})
+	})
+
+	value := func(key string) string {
+		v, err := get(app, key)
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return "<missing>"
+		}
+		So(err, ShouldBeNil)
+		return v
+	}
+
+	Convey("Migrate", t, func() {
+		So(app.DB.Update(func(txn *badger.Txn) error {
+			for i := range keys {
+				if err := txn.Set(fmt.Appendf(nil, "m/%04d", i), []byte("v1")); err != nil {
+					return err
+				}
+			}
+			return txn.Set([]byte("m/drop"), []byte("v1"))
+		}), ShouldBeNil)
+
+		// a dry run reports without writing
+		reports, err := app.Migrate(ctx, true)
+		So(err, ShouldBeNil)
+		So(reports, ShouldHaveLength, 1)
+		So(reports[0].Changed, ShouldEqual, keys)
+		So(reports[0].Deleted, ShouldEqual, 1)
+		So(value("m/0000"), ShouldEqual, "v1")
+		version, err := app.SchemaVersion()
+		So(err, ShouldBeNil)
+		So(version, ShouldEqual, 0)
+
+		// an interrupted rewrite keeps its committed batches
+		calls, failAt = 0, 1500
+		_, err = app.Migrate(ctx, false)
+		So(err, ShouldNotBeNil)
+		So(value("m/0000"), ShouldEqual, "v2")
+		So(value("m/2000"), ShouldEqual, "v1")
+
+		// and resumes after the last one
+		calls, failAt = 0, 0
+		reports, err = app.Migrate(ctx, false)
+		So(err, ShouldBeNil)
+		So(calls, ShouldEqual, keys+1-migrationBatchSize)
+		So(reports[0].Scanned, ShouldEqual, calls)
+		So(value("m/2000"), ShouldEqual, "v2")
+		So(value("m/drop"), ShouldEqual, "<missing>")
+		version, err = app.SchemaVersion()
+		So(err, ShouldBeNil)
+		So(version, ShouldEqual, 1)
+
+		reports, err = app.Migrate(ctx, false)
+		So(err, ShouldBeNil)
+		So(reports, ShouldBeEmpty)
+
+		// stores migrated by newer code are refused
+		So(app.DB.Update(func(txn *badger.Txn) error {
+			return txn.Set(reservedKey("schema-version"), []byte{0, 0, 0, 0, 0, 0, 0, 2})
+		}), ShouldBeNil)
+		_, err = app.Migrate(ctx, false)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestMigrateUpdate(t *testing.T) {
+	Convey("Update steps", t, func() {
+		app := newMemoryApp(t)
+		app.RegisterMigration(1, "flag", func(ctx context.Context, m *Migration) error {
+			return m.Update(func(txn *badger.Txn) error {
+				return txn.Set([]byte("flag"), []byte("on"))
+			})
+		})
+
+		// a dry run runs them without writing nor reporting their changes
+		reports, err := app.Migrate(t.Context(), true)
+		So(err, ShouldBeNil)
+		So(reports, ShouldHaveLength, 1)
+		So(reports[0].Changed, ShouldEqual, 0)
+		_, err = get(app, "flag")
+		So(err, ShouldEqual, badger.ErrKeyNotFound)
+
+		_, err = app.Migrate(t.Context(), false)
+		So(err, ShouldBeNil)
+		value, err := get(app, "flag")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, "on")
+	})
+}
@@ -0,0 +1,259 @@
+package badger
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"go.uber.org/zap"
+)
+
+// ErrLocked matches the *LockError of stores whose directory another process
+// holds.
+var ErrLocked = errors.New("badger store is locked")
+
+const (
+	lockRetryInterval = 100 * time.Millisecond
+	// logHeaderSize is the size of the header of value log and memtable
+	// files, the id of their data key followed by the base IV.
+	logHeaderSize = 8 + 12
+)
+
+type startupConfig struct {
+	LockTimeout time.Duration `mapstructure:"lock_timeout"`
+	// AutoTruncate empties the memtable logs and the last value log whose
+	// header is unreadable, and opens a read-only store that fails to open
+	// read-write once, so badger truncates its logs at the last valid entry.
+	// The writes held by the dropped parts are lost, values of the last value
+	// log the tables reference included.
+	AutoTruncate bool `mapstructure:"auto_truncate"`
+}
+
+// LockError is returned when the directory of the store stayed locked by
+// another process for the whole lock timeout.
+type LockError struct {
+	Dir string
+	// PID is the process holding the lock, 0 when unknown. Processes opening
+	// the store read-only do not record their PID.
+	PID int
+}
+
+func (e *LockError) Error() string {
+	switch {
+	case e.PID == os.Getpid():
+		return fmt.Sprintf("%s: %s is already open in this process", ErrLocked, e.Dir)
+	case e.PID > 0:
+		return fmt.Sprintf("%s: %s is held by process %d", ErrLocked, e.Dir, e.PID)
+	default:
+		return fmt.Sprintf("%s: %s is held by another process, possibly one opening it read-only", ErrLocked, e.Dir)
+	}
+}
+
+func (e *LockError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+// lockHolder returns the PID recorded in the lock file of dir, 0 when there
+// is none.
+func lockHolder(dir string) int {
+	data, err := os.ReadFile(filepath.Join(dir, "LOCK"))
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// lockedDir returns the directory of the store another handle holds, if any.
+func lockedDir(opts badger.Options) (string, error) {
+	if opts.InMemory {
+		return "", nil
+	}
+	for _, dir := range []string{opts.Dir, opts.ValueDir} {
+		locked, err := dirLocked(dir, opts.ReadOnly)
+		if err != nil || locked {
+			return dir, err
+		}
+	}
+	return "", nil
+}
+
+// open opens the store described by opts. A locked directory is retried until
+// the lock timeout passes. With auto truncation enabled, corrupt logs are
+// truncated once before giving up.
+func (db *BadgerApp) open(ctx context.Context, opts badger.Options) (*badger.DB, error) {
+	deadline := time.Now().Add(db.config.Startup.LockTimeout)
+	var waiting, recovered bool
+	for {
+		dir, err := lockedDir(opts)
+		if err != nil {
+			return nil, err
+		}
+		if dir != "" {
+			pid := lockHolder(dir)
+			if time.Now().After(deadline) {
+				return nil, &LockError{Dir: dir, PID: pid}
+			}
+			if !waiting {
+				waiting = true
+				db.Logger.Warn("badger store is locked, waiting for it",
+					zap.String("dir", dir), zap.Int("pid", pid), zap.Duration("timeout", db.config.Startup.LockTimeout))
+			}
+			if err := sleep(ctx, lockRetryInterval); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		d, err := badger.Open(opts)
+		if err == nil {
+			return d, nil
+		}
+		if dir, _ := lockedDir(opts); dir != "" {
+			// locked since the check
+			continue
+		}
+		if errors.Is(err, badger.ErrEncryptionKeyMismatch) {
+			if len(opts.EncryptionKey) == 0 {
+				return nil, fmt.Errorf("badger store %s is encrypted but no encryption key is configured: %w", opts.Dir, err)
+			}
+			return nil, fmt.Errorf("badger encryption key does not match the one used to create store %s: %w", opts.Dir, err)
+		}
+		if opts.InMemory {
+			return nil, err
+		}
+
+		corrupt, cerr := corruptLogs(opts)
+		if cerr != nil {
+			return nil, errors.Join(err, fmt.Errorf("check logs of badger store %s: %w", opts.Dir, cerr))
+		}
+		switch {
+		case !db.config.Startup.AutoTruncate && len(corrupt) > 0:
+			return nil, fmt.Errorf("badger store %s has unreadable logs %s, restore it from a backup or set %s to drop them: %w",
+				opts.Dir, strings.Join(corrupt, ", "), db.config.key("startup.auto_truncate"), err)
+		case !db.config.Startup.AutoTruncate || recovered:
+			return nil, err
+		case len(corrupt) > 0 && opts.ReadOnly:
+			return nil, fmt.Errorf("badger store %s has unreadable logs %s, open it read-write to truncate them: %w",
+				opts.Dir, strings.Join(corrupt, ", "), err)
+		}
+		recovered = true
+
+		if len(corrupt) > 0 {
+			for _, path := range corrupt {
+				db.Logger.Error("badger log is unreadable, truncating it and losing the writes it holds",
+					zap.String("dir", opts.Dir), zap.String("file", path), zap.Error(err))
+				if err := truncateLog(path); err != nil {
+					return nil, fmt.Errorf("truncate unreadable log %s: %w", path, err)
+				}
+			}
+			continue
+		}
+		if opts.ReadOnly {
+			// badger truncates the logs at their last valid entry when opened
+			// read-write, which a store not closed cleanly needs before it
+			// opens read-only
+			db.Logger.Warn("badger store failed to open read-only, opening it read-write once to truncate its logs",
+				zap.String("dir", opts.Dir), zap.Error(err))
+			rw, rwErr := badger.Open(opts.WithReadOnly(false))
+			if rwErr == nil {
+				rwErr = rw.Close()
+			}
+			if rwErr != nil {
+				return nil, errors.Join(err, fmt.Errorf("truncate logs of badger store %s: %w", opts.Dir, rwErr))
+			}
+			continue
+		}
+		return nil, err
+	}
+}
+
+// corruptLogs returns the memtable logs and the last value log of the store
+// whose header names a data key the store does not have, which badger cannot
+// read. A torn tail badger truncates itself when opening the store
+// read-write. Older value logs are never reported: the tables reference their
+// values, they can only be restored from a backup.
+func corruptLogs(opts badger.Options) ([]string, error) {
+	var logs []string
+	mems, err := filepath.Glob(filepath.Join(opts.Dir, "*.mem"))
+	if err != nil {
+		return nil, err
+	}
+	logs = append(logs, mems...)
+	vlogs, err := filepath.Glob(filepath.Join(opts.ValueDir, "*.vlog"))
+	if err != nil {
+		return nil, err
+	}
+	// value logs are named after their zero padded file id
+	slices.Sort(vlogs)
+	if len(vlogs) > 0 {
+		logs = append(logs, vlogs[len(vlogs)-1])
+	}
+	if len(logs) == 0 {
+		return nil, nil
+	}
+
+	registry, err := badger.OpenKeyRegistry(badger.KeyRegistryOptions{
+		Dir:                           opts.Dir,
+		ReadOnly:                      true,
+		EncryptionKey:                 opts.EncryptionKey,
+		EncryptionKeyRotationDuration: opts.EncryptionKeyRotationDuration,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer registry.Close()
+
+	var corrupt []string
+	for _, path := range logs {
+		keyID, ok, err := logKeyID(path)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if _, err := registry.DataKey(keyID); err != nil {
+			corrupt = append(corrupt, path)
+		}
+	}
+	return corrupt, nil
+}
+
+// logKeyID reads the data key id from the header of the log at path, ok is
+// false when the log is shorter than its header, which badger recreates.
+func logKeyID(path string) (uint64, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+	var header [logHeaderSize]byte
+	if _, err := io.ReadFull(f, header[:]); errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return binary.BigEndian.Uint64(header[:8]), true, nil
+}
+
+// truncateLog empties the log at path down to a zeroed header, which badger
+// reads as an empty unencrypted log.
+func truncateLog(path string) error {
+	if err := os.Truncate(path, 0); err != nil {
+		return err
+	}
+	return os.Truncate(path, logHeaderSize)
+}
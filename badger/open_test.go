@@ -0,0 +1,111 @@
+package badger
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newStartupApp returns an app configured to open dir, left to the test to
+// initialize.
+func newStartupApp(dir string, startup startupConfig) *BadgerApp {
+	app := New("")
+	app.config = testConfig(dir)
+	app.config.Startup = startup
+	return app
+}
+
+func TestStartup(t *testing.T) {
+	Convey("A locked store", t, func() {
+		dir := t.TempDir()
+		holder := newDiskApp(t, dir)
+		// closing again once released is safe
+		defer holder.Close(t.Context())
+
+		app := newStartupApp(dir, startupConfig{LockTimeout: 200 * time.Millisecond})
+		start := time.Now()
+		err := app.initialize(t.Context())
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 200*time.Millisecond)
+		So(errors.Is(err, ErrLocked), ShouldBeTrue)
+		var lockErr *LockError
+		So(errors.As(err, &lockErr), ShouldBeTrue)
+		So(lockErr.PID, ShouldEqual, os.Getpid())
+		So(lockErr.Dir, ShouldEqual, dir)
+		So(err.Error(), ShouldContainSubstring, "already open in this process")
+
+		// closing an app that failed to open does nothing
+		So(func() { app.Close(t.Context()) }, ShouldNotPanic)
+
+		// the lock is taken once released within the timeout
+		released := make(chan struct{})
+		go func() {
+			defer close(released)
+			time.Sleep(200 * time.Millisecond)
+			holder.Close(t.Context())
+		}()
+		app = newStartupApp(dir, startupConfig{LockTimeout: 5 * time.Second})
+		So(app.initialize(t.Context()), ShouldBeNil)
+		<-released
+		app.Close(t.Context())
+		So(func() { app.Close(t.Context()) }, ShouldNotPanic)
+	})
+
+	Convey("A corrupt value log", t, func() {
+		dir := t.TempDir()
+		app := newDiskApp(t, dir)
+		So(set(app, "key", "value"), ShouldBeNil)
+		app.Close(t.Context())
+
+		vlogs, err := filepath.Glob(filepath.Join(dir, "*.vlog"))
+		So(err, ShouldBeNil)
+		So(vlogs, ShouldNotBeEmpty)
+		vlog := vlogs[len(vlogs)-1]
+		f, err := os.OpenFile(vlog, os.O_WRONLY, 0)
+		So(err, ShouldBeNil)
+		_, err = f.WriteAt([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, 0)
+		So(err, ShouldBeNil)
+		So(f.Close(), ShouldBeNil)
+
+		app = newStartupApp(dir, startupConfig{})
+		err = app.initialize(t.Context())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "badger.startup.auto_truncate")
+		So(err.Error(), ShouldContainSubstring, vlog)
+
+		app = newStartupApp(dir, startupConfig{AutoTruncate: true})
+		So(app.initialize(t.Context()), ShouldBeNil)
+		defer app.Close(t.Context())
+		// the key lives in the flushed tables, not the truncated log
+		value, err := get(app, "key")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, "value")
+		So(set(app, "after", "recovery"), ShouldBeNil)
+	})
+
+	Convey("A store not closed cleanly opened read-only", t, func() {
+		dir := t.TempDir()
+		app := newDiskApp(t, filepath.Join(dir, "db"))
+		defer app.Close(t.Context())
+		So(set(app, "key", "value"), ShouldBeNil)
+		// a copy of the open store has a memtable log to truncate
+		copied := filepath.Join(dir, "copy")
+		So(os.CopyFS(copied, os.DirFS(filepath.Join(dir, "db"))), ShouldBeNil)
+		So(os.Remove(filepath.Join(copied, "LOCK")), ShouldBeNil)
+
+		readOnly := newStartupApp(copied, startupConfig{})
+		readOnly.config.ReadOnly = true
+		So(readOnly.initialize(t.Context()), ShouldNotBeNil)
+
+		readOnly = newStartupApp(copied, startupConfig{AutoTruncate: true})
+		readOnly.config.ReadOnly = true
+		So(readOnly.initialize(t.Context()), ShouldBeNil)
+		defer readOnly.Close(t.Context())
+		value, err := get(readOnly, "key")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, "value")
+	})
+}
@@ -0,0 +1,346 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+var (
+	// ErrQueueEmpty is returned by TryDequeue when no message is ready.
+	ErrQueueEmpty = errors.New("queue is empty")
+	// ErrStaleMessage is returned when acking or nacking a message whose
+	// visibility timeout expired and that may have been delivered again.
+	ErrStaleMessage = errors.New("message is no longer held by this consumer")
+)
+
+// Message is a message delivered by a Queue. It has to be acked or nacked
+// before its visibility timeout expires.
+type Message struct {
+	ID         uint64
+	Payload    []byte
+	Attempts   int
+	EnqueuedAt time.Time
+
+	visibleAt uint64
+}
+
+type queueRecord struct {
+	Payload    []byte    `msgpack:"payload"`
+	Attempts   int       `msgpack:"attempts"`
+	EnqueuedAt time.Time `msgpack:"enqueued_at"`
+}
+
+type queueOptions struct {
+	visibilityTimeout time.Duration
+	maxAttempts       int
+	deadLetter        string
+	pollInterval      time.Duration
+}
+
+type QueueOption func(*queueOptions)
+
+// WithVisibilityTimeout sets how long a dequeued message stays hidden from
+// other consumers before it is delivered again. The default is 30 seconds.
+func WithVisibilityTimeout(d time.Duration) QueueOption {
+	return func(o *queueOptions) {
+		o.visibilityTimeout = d
+	}
+}
+
+// WithMaxAttempts moves a message to the dead-letter queue once it has been
+// delivered n times without being acked. 0, the default, retries forever.
+func WithMaxAttempts(n int) QueueOption {
+	return func(o *queueOptions) {
+		o.maxAttempts = n
+	}
+}
+
+// WithDeadLetterQueue sets the queue receiving messages that exceeded the
+// maximum attempts. The default is the queue name suffixed with ".dead".
+func WithDeadLetterQueue(name string) QueueOption {
+	return func(o *queueOptions) {
+		o.deadLetter = name
+	}
+}
+
+// WithPollInterval sets how often a blocked Dequeue looks for messages
+// enqueued by other Queue values. The default is one second.
+func WithPollInterval(d time.Duration) QueueOption {
+	return func(o *queueOptions) {
+		o.pollInterval = d
+	}
+}
+
+// Queue is a durable FIFO queue stored in a BadgerApp. Several named queues
+// can live in the same DB. Messages are delivered at least once.
+type Queue struct {
+	db      *BadgerApp
+	name    string
+	options queueOptions
+	notify  chan struct{}
+}
+
+// NewQueue returns the queue called name.
+func NewQueue(db *BadgerApp, name string, opts ...QueueOption) *Queue {
+	if !validName(name) {
+		panic(fmt.Errorf("invalid queue name %q", name))
+	}
+	q := &Queue{
+		db:   db,
+		name: name,
+		options: queueOptions{
+			visibilityTimeout: 30 * time.Second,
+			deadLetter:        name + ".dead",
+			pollInterval:      time.Second,
+		},
+		notify: make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(&q.options)
+	}
+	return q
+}
+
+// Enqueue adds payload to the queue and returns the message id.
+func (q *Queue) Enqueue(ctx context.Context, payload []byte) (uint64, error) {
+	return q.EnqueueDelayed(ctx, payload, 0)
+}
+
+// EnqueueDelayed adds payload to the queue, to be delivered once delay has
+// passed.
+func (q *Queue) EnqueueDelayed(ctx context.Context, payload []byte, delay time.Duration) (uint64, error) {
+	id, err := q.nextID()
+	if err != nil {
+		return 0, err
+	}
+
+	err = q.db.UpdateContext(ctx, func(txn *Txn) error {
+		record := queueRecord{Payload: payload, EnqueuedAt: time.Now()}
+		return q.put(txn, q.name, id, deadline(delay), record)
+	}, WithSpanName("badger.Queue.Enqueue"), WithKeyPrefix(q.indexPrefix(q.name)))
+	if err != nil {
+		return 0, err
+	}
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return id, nil
+}
+
+// Dequeue waits until a message is ready and delivers it. Consumers racing
+// for the same message try again until one is theirs.
+func (q *Queue) Dequeue(ctx context.Context) (*Message, error) {
+	for {
+		msg, next, err := q.dequeue(ctx)
+		if errors.Is(err, badger.ErrConflict) {
+			continue
+		}
+		if !errors.Is(err, ErrQueueEmpty) {
+			return msg, err
+		}
+
+		wait := q.options.pollInterval
+		if !next.IsZero() {
+			wait = min(wait, time.Until(next))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-q.notify:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// TryDequeue delivers a ready message or returns ErrQueueEmpty. Like Dequeue
+// it tries again when another consumer took the message first.
+func (q *Queue) TryDequeue(ctx context.Context) (*Message, error) {
+	for {
+		msg, _, err := q.dequeue(ctx)
+		if !errors.Is(err, badger.ErrConflict) {
+			return msg, err
+		}
+	}
+}
+
+// Ack removes a delivered message from the queue.
+func (q *Queue) Ack(ctx context.Context, msg *Message) error {
+	return q.db.UpdateWithRetry(ctx, func(txn *Txn) error {
+		if err := q.hold(txn, msg); err != nil {
+			return err
+		}
+		if err := txn.Delete(q.indexKey(q.name, msg.visibleAt, msg.ID)); err != nil {
+			return err
+		}
+		return txn.Delete(q.recordKey(q.name, msg.ID))
+	}, WithSpanName("badger.Queue.Ack"), quotaExempt())
+}
+
+// Nack returns a delivered message to the queue, to be delivered again after
+// delay.
+func (q *Queue) Nack(ctx context.Context, msg *Message, delay time.Duration) error {
+	return q.db.UpdateWithRetry(ctx, func(txn *Txn) error {
+		if err := q.hold(txn, msg); err != nil {
+			return err
+		}
+		if err := txn.Delete(q.indexKey(q.name, msg.visibleAt, msg.ID)); err != nil {
+			return err
+		}
+		return txn.Set(q.indexKey(q.name, deadline(delay), msg.ID), nil)
+	}, WithSpanName("badger.Queue.Nack"))
+}
+
+// Len returns the number of messages in the queue, including delivered but
+// not yet acked ones.
+func (q *Queue) Len() (int, error) {
+	n := 0
+	err := q.db.DB.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = q.indexPrefix(q.name)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// dequeue delivers the first ready message. When the queue holds no ready
+// message it returns ErrQueueEmpty and the time the next one becomes ready.
+// Messages found past their maximum attempts on the way are moved to the
+// dead-letter queue in the same transaction.
+func (q *Queue) dequeue(ctx context.Context) (msg *Message, next time.Time, err error) {
+	err = q.db.UpdateWithRetry(ctx, func(txn *Txn) error {
+		msg, next = nil, time.Time{}
+		now := uint64(time.Now().UnixNano())
+
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = q.indexPrefix(q.name)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			visibleAt, id := q.parseIndexKey(it.Item().Key())
+			if visibleAt > now {
+				next = time.Unix(0, int64(visibleAt))
+				return nil
+			}
+
+			record, err := q.record(txn, id)
+			if err != nil {
+				return err
+			}
+			if err := txn.Delete(it.Item().KeyCopy(nil)); err != nil {
+				return err
+			}
+
+			record.Attempts++
+			if q.options.maxAttempts > 0 && record.Attempts > q.options.maxAttempts {
+				if err := txn.Delete(q.recordKey(q.name, id)); err != nil {
+					return err
+				}
+				record.Attempts = 0
+				if err := q.put(txn, q.options.deadLetter, id, now, record); err != nil {
+					return err
+				}
+				continue
+			}
+
+			msg = &Message{
+				ID:         id,
+				Payload:    record.Payload,
+				Attempts:   record.Attempts,
+				EnqueuedAt: record.EnqueuedAt,
+				visibleAt:  deadline(q.options.visibilityTimeout),
+			}
+			return q.put(txn, q.name, id, msg.visibleAt, record)
+		}
+		return nil
+	}, WithSpanName("badger.Queue.Dequeue"), WithKeyPrefix(q.indexPrefix(q.name)))
+	if err == nil && msg == nil {
+		err = ErrQueueEmpty
+	}
+	return msg, next, err
+}
+
+// hold checks that msg is still hidden with the deadline it was delivered
+// with, that is, no other consumer received it since.
+func (q *Queue) hold(txn *Txn, msg *Message) error {
+	_, err := txn.Get(q.indexKey(q.name, msg.visibleAt, msg.ID))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return ErrStaleMessage
+	}
+	return err
+}
+
+func (q *Queue) put(txn *Txn, queue string, id uint64, visibleAt uint64, record queueRecord) error {
+	value, err := MsgpackCodec[queueRecord]{}.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := txn.Set(q.recordKey(queue, id), value); err != nil {
+		return err
+	}
+	return txn.Set(q.indexKey(queue, visibleAt, id), nil)
+}
+
+func (q *Queue) record(txn *Txn, id uint64) (record queueRecord, err error) {
+	item, err := txn.Get(q.recordKey(q.name, id))
+	if err != nil {
+		return record, err
+	}
+	err = item.Value(func(val []byte) error {
+		record, err = MsgpackCodec[queueRecord]{}.Unmarshal(val)
+		return err
+	})
+	return record, err
+}
+
+// nextID returns an id unique across all queues, so that messages keep it
+// when moved to a dead-letter queue.
+func (q *Queue) nextID() (uint64, error) {
+	seq, err := q.db.Sequence("queue")
+	if err != nil {
+		return 0, err
+	}
+	return seq.Next()
+}
+
+// The index orders the messages of a queue by the time they become visible,
+// delivered messages are re-keyed with the end of their visibility timeout.
+func (q *Queue) indexPrefix(queue string) []byte {
+	return append(reservedKey("queue", queue, "index"), 0)
+}
+
+func (q *Queue) indexKey(queue string, visibleAt uint64, id uint64) []byte {
+	key := binary.BigEndian.AppendUint64(q.indexPrefix(queue), visibleAt)
+	return binary.BigEndian.AppendUint64(key, id)
+}
+
+func (q *Queue) parseIndexKey(key []byte) (visibleAt uint64, id uint64) {
+	key = bytes.TrimPrefix(key, q.indexPrefix(q.name))
+	return binary.BigEndian.Uint64(key[:8]), binary.BigEndian.Uint64(key[8:16])
+}
+
+func (q *Queue) recordKey(queue string, id uint64) []byte {
+	return binary.BigEndian.AppendUint64(append(reservedKey("queue", queue, "record"), 0), id)
+}
+
+func deadline(d time.Duration) uint64 {
+	return uint64(time.Now().Add(d).UnixNano())
+}
@@ -0,0 +1,177 @@
+package badger
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestQueue(t *testing.T) {
+	app := newMemoryApp(t)
+	ctx := t.Context()
+
+	Convey("Queue", t, func() {
+		Convey("delivers in order and acks", func() {
+			q := NewQueue(app, "order")
+
+			_, err := q.Enqueue(ctx, []byte("a"))
+			So(err, ShouldBeNil)
+			_, err = q.Enqueue(ctx, []byte("b"))
+			So(err, ShouldBeNil)
+
+			msg, err := q.Dequeue(ctx)
+			So(err, ShouldBeNil)
+			So(string(msg.Payload), ShouldEqual, "a")
+			So(msg.Attempts, ShouldEqual, 1)
+			So(q.Ack(ctx, msg), ShouldBeNil)
+
+			msg, err = q.Dequeue(ctx)
+			So(err, ShouldBeNil)
+			So(string(msg.Payload), ShouldEqual, "b")
+			So(q.Ack(ctx, msg), ShouldBeNil)
+
+			_, err = q.TryDequeue(ctx)
+			So(err, ShouldEqual, ErrQueueEmpty)
+			n, err := q.Len()
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 0)
+		})
+
+		Convey("redelivers after the visibility timeout", func() {
+			q := NewQueue(app, "visibility", WithVisibilityTimeout(50*time.Millisecond), WithPollInterval(10*time.Millisecond))
+
+			_, err := q.Enqueue(ctx, []byte("a"))
+			So(err, ShouldBeNil)
+			first, err := q.Dequeue(ctx)
+			So(err, ShouldBeNil)
+			_, err = q.TryDequeue(ctx)
+			So(err, ShouldEqual, ErrQueueEmpty)
+
+			second, err := q.Dequeue(ctx)
+			So(err, ShouldBeNil)
+			So(second.ID, ShouldEqual, first.ID)
+			So(second.Attempts, ShouldEqual, 2)
+			So(q.Ack(ctx, first), ShouldEqual, ErrStaleMessage)
+			So(q.Ack(ctx, second), ShouldBeNil)
+		})
+
+		Convey("delays delivery", func() {
+			q := NewQueue(app, "delayed", WithPollInterval(time.Second))
+
+			_, err := q.EnqueueDelayed(ctx, []byte("a"), 50*time.Millisecond)
+			So(err, ShouldBeNil)
+			_, err = q.TryDequeue(ctx)
+			So(err, ShouldEqual, ErrQueueEmpty)
+
+			start := time.Now()
+			msg, err := q.Dequeue(ctx)
+			So(err, ShouldBeNil)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+			So(q.Nack(ctx, msg, time.Hour), ShouldBeNil)
+			_, err = q.TryDequeue(ctx)
+			So(err, ShouldEqual, ErrQueueEmpty)
+		})
+
+		Convey("moves failing messages to the dead-letter queue", func() {
+			q := NewQueue(app, "failing", WithMaxAttempts(2))
+			dead := NewQueue(app, "failing.dead")
+
+			_, err := q.Enqueue(ctx, []byte("a"))
+			So(err, ShouldBeNil)
+			for range 2 {
+				msg, err := q.TryDequeue(ctx)
+				So(err, ShouldBeNil)
+				So(q.Nack(ctx, msg, 0), ShouldBeNil)
+			}
+			_, err = q.TryDequeue(ctx)
+			So(err, ShouldEqual, ErrQueueEmpty)
+
+			msg, err := dead.TryDequeue(ctx)
+			So(err, ShouldBeNil)
+			So(string(msg.Payload), ShouldEqual, "a")
+		})
+
+		Convey("stops waiting when the context is done", func() {
+			q := NewQueue(app, "idle")
+			ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+			defer cancel()
+			_, err := q.Dequeue(ctx)
+			So(err, ShouldEqual, context.DeadlineExceeded)
+		})
+
+		Convey("delivers each message once to concurrent consumers", func() {
+			// the test store does not retry updates, every consumer losing
+			// the race for the head gets the conflict
+			q := NewQueue(app, "racing", WithVisibilityTimeout(time.Minute))
+			const messages, consumers = 200, 8
+			for i := range messages {
+				_, err := q.Enqueue(ctx, fmt.Appendf(nil, "%d", i))
+				So(err, ShouldBeNil)
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			defer cancel()
+			var mu sync.Mutex
+			delivered := map[string]int{}
+			var total int
+			var errs []error
+			var wg sync.WaitGroup
+			for range consumers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						msg, err := q.Dequeue(ctx)
+						if ctx.Err() != nil {
+							return
+						}
+						if err == nil {
+							err = q.Ack(ctx, msg)
+						}
+						mu.Lock()
+						if err != nil {
+							errs = append(errs, err)
+						} else {
+							delivered[string(msg.Payload)]++
+							if total++; total == messages {
+								cancel()
+							}
+						}
+						mu.Unlock()
+						if err != nil {
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			So(errs, ShouldBeEmpty)
+			So(delivered, ShouldHaveLength, messages)
+			for _, n := range delivered {
+				So(n, ShouldEqual, 1)
+			}
+		})
+	})
+
+	Convey("Queue survives a restart", t, func() {
+		dir := filepath.Join(t.TempDir(), "db")
+		app := newDiskApp(t, dir)
+		q := NewQueue(app, "jobs")
+		_, err := q.Enqueue(ctx, []byte("a"))
+		So(err, ShouldBeNil)
+		app.Close(ctx)
+
+		app = newDiskApp(t, dir)
+		defer app.Close(ctx)
+		q = NewQueue(app, "jobs")
+		msg, err := q.TryDequeue(ctx)
+		So(err, ShouldBeNil)
+		So(string(msg.Payload), ShouldEqual, "a")
+	})
+}
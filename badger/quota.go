@@ -0,0 +1,232 @@
+package badger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"go.uber.org/zap"
+)
+
+// ErrQuotaExceeded matches the *QuotaError of writes rejected while a hard
+// limit of the quota is exceeded.
+var ErrQuotaExceeded = errors.New("badger storage quota exceeded")
+
+// QuotaLimits are the limits of the storage quota, 0 disables a limit. The
+// size limits apply to the tables and value logs of the store, the minimums
+// to the free space of the filesystems holding them.
+//
+// The hard limits reject the writes made through BadgerApp: Update,
+// UpdateContext, UpdateWithRetry, BatchWriter, Store, Load and Restore.
+// Transactions from NewTransaction, batches from NewWriteBatch, stream writers
+// and any other use of the embedded *badger.DB are not checked.
+type QuotaLimits struct {
+	SoftLimit   int64 `mapstructure:"soft_limit"`
+	HardLimit   int64 `mapstructure:"hard_limit"`
+	SoftMinFree int64 `mapstructure:"soft_min_free"`
+	HardMinFree int64 `mapstructure:"hard_min_free"`
+}
+
+func (l QuotaLimits) enabled() bool {
+	return l.SoftLimit > 0 || l.HardLimit > 0 || l.SoftMinFree > 0 || l.HardMinFree > 0
+}
+
+func (l QuotaLimits) validate() error {
+	if l.SoftLimit > 0 && l.HardLimit > 0 && l.SoftLimit > l.HardLimit {
+		return fmt.Errorf("soft limit %d exceeds hard limit %d", l.SoftLimit, l.HardLimit)
+	}
+	if l.SoftMinFree > 0 && l.SoftMinFree < l.HardMinFree {
+		return fmt.Errorf("soft minimum free space %d is below hard minimum %d", l.SoftMinFree, l.HardMinFree)
+	}
+	return nil
+}
+
+type quotaConfig struct {
+	QuotaLimits `mapstructure:",squash"`
+	Interval    time.Duration `mapstructure:"interval"`
+}
+
+func (c quotaConfig) validate() error {
+	if err := c.QuotaLimits.validate(); err != nil {
+		return err
+	}
+	if c.enabled() && c.Interval <= 0 {
+		return errors.New("interval must be positive when a limit is set")
+	}
+	return nil
+}
+
+// QuotaError is returned by writes rejected because the store outgrew its hard
+// limit or the free space of its filesystem fell below the hard minimum.
+// Writes are accepted again once the next check finds enough space, after
+// deletions and value log GC reclaimed it.
+type QuotaError struct {
+	// Resource is "size" for the store size, "free space" for the
+	// filesystem.
+	Resource string
+	Value    int64
+	Limit    int64
+}
+
+func (e *QuotaError) Error() string {
+	if e.Resource == "free space" {
+		return fmt.Sprintf("%s: %d bytes free on the filesystem, below the minimum of %d", ErrQuotaExceeded, e.Value, e.Limit)
+	}
+	return fmt.Sprintf("%s: store uses %d bytes, above the limit of %d", ErrQuotaExceeded, e.Value, e.Limit)
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// quotaState holds the limits of the quota and the outcome of the last check.
+type quotaState struct {
+	limits atomic.Pointer[QuotaLimits]
+
+	soft atomic.Bool
+	hard atomic.Pointer[QuotaError]
+	// free is the free space of the filesystem, -1 when unknown
+	free atomic.Int64
+}
+
+// checkQuota returns the error of the exceeded hard limit, if any.
+func (db *BadgerApp) checkQuota() error {
+	if err := db.quota.hard.Load(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetQuota replaces the limits of the storage quota of the open store and
+// checks them right away. Unless quota.interval is 0, they are checked
+// periodically afterwards as well.
+func (db *BadgerApp) SetQuota(limits QuotaLimits) error {
+	if err := limits.validate(); err != nil {
+		return err
+	}
+	db.quota.limits.Store(&limits)
+	db.updateQuota()
+	return nil
+}
+
+// quotaEnabled reports whether any limit of the quota is set.
+func (db *BadgerApp) quotaEnabled() bool {
+	limits := db.quota.limits.Load()
+	return limits != nil && limits.enabled()
+}
+
+func (db *BadgerApp) runQuota(ctx context.Context) {
+	ticker := time.NewTicker(db.config.Quota.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if db.quotaEnabled() {
+			db.updateQuota()
+		}
+	}
+}
+
+// updateQuota measures the store and its filesystem, and logs the limits
+// crossed since the previous check in either direction.
+func (db *BadgerApp) updateQuota() {
+	conf := *db.quota.limits.Load()
+	opts := db.DB.Opts()
+	lsm, vlog := db.DB.Size()
+	used := lsm + vlog
+	free := int64(-1)
+	if !opts.InMemory {
+		var err error
+		if used, err = storeSize(opts.Dir, opts.ValueDir); err != nil {
+			db.Logger.Warn("measure size of badger store failed", zap.Error(err))
+			used = lsm + vlog
+		}
+		if free, err = freeSpace(opts.Dir, opts.ValueDir); err != nil {
+			db.Logger.Warn("measure free space of badger store failed", zap.Error(err))
+			free = -1
+		}
+	}
+	db.quota.free.Store(free)
+
+	var hard *QuotaError
+	switch {
+	case conf.HardLimit > 0 && used >= conf.HardLimit:
+		hard = &QuotaError{Resource: "size", Value: used, Limit: conf.HardLimit}
+	case conf.HardMinFree > 0 && free >= 0 && free < conf.HardMinFree:
+		hard = &QuotaError{Resource: "free space", Value: free, Limit: conf.HardMinFree}
+	}
+	soft := hard != nil ||
+		conf.SoftLimit > 0 && used >= conf.SoftLimit ||
+		conf.SoftMinFree > 0 && free >= 0 && free < conf.SoftMinFree
+
+	fields := []zap.Field{zap.Int64("size", used), zap.Int64("free", free)}
+	previous := db.quota.hard.Swap(hard)
+	switch {
+	case hard != nil && previous == nil:
+		db.Logger.Error("badger hard quota exceeded, rejecting writes", append(fields, zap.Error(hard))...)
+	case hard == nil && previous != nil:
+		db.Logger.Info("badger store is back under its hard quota, accepting writes", fields...)
+	}
+	if wasSoft := db.quota.soft.Swap(soft); soft && !wasSoft && hard == nil {
+		db.Logger.Warn("badger soft quota exceeded", append(fields,
+			zap.Int64("soft_limit", conf.SoftLimit), zap.Int64("soft_min_free", conf.SoftMinFree))...)
+	} else if !soft && wasSoft {
+		db.Logger.Info("badger store is back under its soft quota", fields...)
+	}
+}
+
+// storeSize sums the size of the table and value log files in dirs. badger
+// only refreshes the size it reports every minute.
+func storeSize(dirs ...string) (int64, error) {
+	var size int64
+	seen := map[string]bool{}
+	for _, dir := range dirs {
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return 0, err
+		}
+		for _, e := range entries {
+			if ext := filepath.Ext(e.Name()); ext != ".sst" && ext != ".vlog" {
+				continue
+			}
+			info, err := e.Info()
+			if errors.Is(err, os.ErrNotExist) {
+				// removed by a compaction or gc meanwhile
+				continue
+			} else if err != nil {
+				return 0, err
+			}
+			size += info.Size()
+		}
+	}
+	return size, nil
+}
+
+// quotaExempt marks the updates the hard quota does not reject: deletions
+// reclaiming space and the bookkeeping of running features.
+func quotaExempt() TraceOption {
+	return func(o *traceOptions) {
+		o.quotaExempt = true
+	}
+}
+
+// update runs fn like Update without checking the quota.
+func (db *BadgerApp) update(fn func(txn *badger.Txn) error) error {
+	err := db.DB.Update(fn)
+	if errors.Is(err, badger.ErrConflict) {
+		db.stats.conflicts.Add(1)
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package badger
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestQuota(t *testing.T) {
+	Convey("Quota", t, func() {
+		app := newDiskApp(t, t.TempDir(), func(app *BadgerApp) {
+			app.config.Quota = quotaConfig{QuotaLimits: QuotaLimits{SoftLimit: 1}, Interval: time.Hour}
+		})
+		defer app.Close(t.Context())
+
+		// the soft limit only warns
+		So(app.quota.soft.Load(), ShouldBeTrue)
+		So(set(app, "key", "value"), ShouldBeNil)
+		So(app.quota.free.Load(), ShouldBeGreaterThan, 0)
+
+		So(app.SetQuota(QuotaLimits{SoftLimit: 1, HardLimit: 1}), ShouldBeNil)
+		err := set(app, "key", "value")
+		So(err, ShouldWrap, ErrQuotaExceeded)
+		var quotaErr *QuotaError
+		So(errors.As(err, &quotaErr), ShouldBeTrue)
+		So(quotaErr.Resource, ShouldEqual, "size")
+		So(quotaErr.Value, ShouldBeGreaterThan, 1)
+
+		w := app.NewBatchWriter()
+		So(errors.Is(w.Set(t.Context(), []byte("key"), []byte("value")), ErrQuotaExceeded), ShouldBeTrue)
+		So(w.Delete(t.Context(), []byte("key")), ShouldBeNil)
+		So(w.Close(), ShouldBeNil)
+
+		// deletions reclaim space
+		store := NewStore[string, string](app, "quota", StringKey{}, JSONCodec[string]{})
+		So(store.Delete("key"), ShouldBeNil)
+		So(errors.Is(store.Put("key", "value"), ErrQuotaExceeded), ShouldBeTrue)
+
+		var backup bytes.Buffer
+		_, err = app.Backup(t.Context(), &backup, 0)
+		So(err, ShouldBeNil)
+		So(errors.Is(app.Restore(t.Context(), &backup), ErrQuotaExceeded), ShouldBeTrue)
+		So(errors.Is(app.Load(&backup, 1), ErrQuotaExceeded), ShouldBeTrue)
+
+		So(app.SetQuota(QuotaLimits{SoftLimit: 1, HardLimit: 1 << 40, HardMinFree: 1 << 62}), ShouldBeNil)
+		So(errors.As(set(app, "key", "value"), &quotaErr), ShouldBeTrue)
+		So(quotaErr.Resource, ShouldEqual, "free space")
+
+		So(app.SetQuota(QuotaLimits{}), ShouldBeNil)
+		So(app.quota.soft.Load(), ShouldBeFalse)
+		So(app.Update(func(txn *badger.Txn) error {
+			return txn.Set([]byte("key"), []byte("value"))
+		}), ShouldBeNil)
+
+		So(app.SetQuota(QuotaLimits{SoftLimit: 2, HardLimit: 1}), ShouldNotBeNil)
+		_, err = (&config{Quota: quotaConfig{QuotaLimits: QuotaLimits{SoftLimit: 2, HardLimit: 1}, Interval: 1}}).options()
+		So(err, ShouldNotBeNil)
+	})
+}
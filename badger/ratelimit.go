@@ -0,0 +1,157 @@
+package badger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/vmihailenco/msgpack/v5"
+	"go.uber.org/zap"
+)
+
+// RateLimiter is a token bucket whose state is kept in a BadgerApp, so limits
+// survive restarts and are shared by every RateLimiter of the same name on
+// the store. The bucket refills at rate tokens per second up to burst tokens,
+// a bucket used for the first time starts full. With conflict detection
+// disabled, concurrent users of a bucket have to share its RateLimiter.
+type RateLimiter struct {
+	db    *BadgerApp
+	name  string
+	key   []byte
+	rate  float64
+	burst int
+	mu    sync.Mutex
+}
+
+type bucketState struct {
+	Tokens    float64   `msgpack:"tokens"`
+	UpdatedAt time.Time `msgpack:"updated_at"`
+}
+
+// NewRateLimiter returns the bucket called name refilling at rate tokens per
+// second up to burst tokens. Changing the rate or burst of an existing bucket
+// keeps its tokens, capped to the new burst.
+func NewRateLimiter(db *BadgerApp, name string, rate float64, burst int) *RateLimiter {
+	if !validName(name) {
+		panic(fmt.Errorf("invalid rate limiter name %q", name))
+	}
+	if rate <= 0 || burst < 1 {
+		panic(fmt.Errorf("rate limiter %s needs a positive rate and burst, got %v and %d", name, rate, burst))
+	}
+	return &RateLimiter{
+		db:    db,
+		name:  name,
+		key:   reservedKey("ratelimit", name),
+		rate:  rate,
+		burst: burst,
+	}
+}
+
+// Allow takes a token if one is available and reports whether it did.
+func (l *RateLimiter) Allow() bool {
+	return l.AllowN(1)
+}
+
+// AllowN takes n tokens if they are available and reports whether it did.
+// Storage errors are logged and deny the request.
+func (l *RateLimiter) AllowN(n int) bool {
+	wait, err := l.take(context.Background(), n)
+	if err != nil {
+		l.db.Logger.Warn("rate limiter failed", zap.String("name", l.name), zap.Error(err))
+		return false
+	}
+	return wait == 0
+}
+
+// Wait blocks until a token is available and takes it, or until ctx is done.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n tokens are available and takes them, or until ctx is
+// done.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	if n > l.burst {
+		return fmt.Errorf("rate limiter %s: %d tokens exceed the burst of %d", l.name, n, l.burst)
+	}
+	for {
+		wait, err := l.take(ctx, n)
+		if err != nil || wait == 0 {
+			return err
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// Tokens returns the number of tokens currently in the bucket.
+func (l *RateLimiter) Tokens() (float64, error) {
+	var tokens float64
+	err := l.db.DB.View(func(txn *badger.Txn) error {
+		state, err := l.load(txn)
+		tokens = l.refill(state, time.Now()).Tokens
+		return err
+	})
+	return tokens, err
+}
+
+// take removes n tokens from the bucket when it holds them, otherwise it
+// leaves the bucket untouched and returns how long until it does.
+func (l *RateLimiter) take(ctx context.Context, n int) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var wait time.Duration
+	err := l.db.UpdateWithRetry(ctx, func(txn *Txn) error {
+		state, err := l.load(txn.Txn)
+		if err != nil {
+			return err
+		}
+		current := l.refill(state, time.Now())
+		if missing := float64(n) - current.Tokens; missing > 0 {
+			wait = time.Duration(missing / l.rate * float64(time.Second))
+			return nil
+		}
+		wait = 0
+		current.Tokens -= float64(n)
+		value, err := msgpack.Marshal(current)
+		if err != nil {
+			return err
+		}
+		return txn.Set(l.key, value)
+	}, WithSpanName("badger.RateLimiter.take"), quotaExempt())
+	return wait, err
+}
+
+// load returns the stored state of the bucket, nil when it was never used.
+func (l *RateLimiter) load(txn *badger.Txn) (*bucketState, error) {
+	item, err := txn.Get(l.key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var state bucketState
+	err = item.Value(func(val []byte) error {
+		return msgpack.Unmarshal(val, &state)
+	})
+	return &state, err
+}
+
+// refill adds the tokens accumulated since the state was stored. Time going
+// backwards adds nothing.
+func (l *RateLimiter) refill(state *bucketState, now time.Time) bucketState {
+	if state == nil {
+		return bucketState{Tokens: float64(l.burst), UpdatedAt: now}
+	}
+	if elapsed := now.Sub(state.UpdatedAt); elapsed > 0 {
+		state.Tokens += elapsed.Seconds() * l.rate
+		state.UpdatedAt = now
+	}
+	state.Tokens = min(state.Tokens, float64(l.burst))
+	return *state
+}
@@ -0,0 +1,63 @@
+package badger
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRateLimiter(t *testing.T) {
+	Convey("RateLimiter", t, func() {
+		dir := t.TempDir()
+		app := newDiskApp(t, dir)
+
+		// a slow bucket does not refill during the test
+		quota := NewRateLimiter(app, "fofa", 0.001, 3)
+		So(quota.Allow(), ShouldBeTrue)
+
+		var allowed atomic.Int64
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if quota.Allow() {
+					allowed.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		So(allowed.Load(), ShouldEqual, 2)
+		So(quota.Allow(), ShouldBeFalse)
+
+		ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+		defer cancel()
+		So(quota.Wait(ctx), ShouldEqual, context.DeadlineExceeded)
+		So(quota.WaitN(t.Context(), 4), ShouldNotBeNil)
+
+		// the empty bucket survives a restart
+		app.Close(t.Context())
+		app = newDiskApp(t, dir)
+		defer app.Close(t.Context())
+		quota = NewRateLimiter(app, "fofa", 0.001, 3)
+		So(quota.Allow(), ShouldBeFalse)
+		tokens, err := quota.Tokens()
+		So(err, ShouldBeNil)
+		So(tokens, ShouldBeLessThan, 1)
+
+		// buckets are independent
+		fast := NewRateLimiter(app, "fast", 50, 1)
+		So(fast.Allow(), ShouldBeTrue)
+		So(fast.Allow(), ShouldBeFalse)
+		start := time.Now()
+		So(fast.Wait(t.Context()), ShouldBeNil)
+		So(fast.Wait(t.Context()), ShouldBeNil)
+		So(time.Since(start), ShouldBeBetween, 20*time.Millisecond, time.Second)
+
+		So(func() { NewRateLimiter(app, "zero", 0, 1) }, ShouldPanic)
+	})
+}
@@ -0,0 +1,554 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/vmihailenco/msgpack/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	// replicationStallTimeout bounds how long the change feed waits for a
+	// follower before dropping it, so that a stuck follower does not stall
+	// the writers of the primary. The follower catches up when it reconnects.
+	replicationStallTimeout = 10 * time.Second
+	// replicationRetryInterval is the wait of a follower between connection
+	// attempts.
+	replicationRetryInterval = time.Second
+)
+
+var errFollowerTooSlow = errors.New("follower does not keep up with the change feed")
+
+type replicationConfig struct {
+	Listen    string        `mapstructure:"listen"`
+	Primary   string        `mapstructure:"primary"`
+	Heartbeat time.Duration `mapstructure:"heartbeat"`
+	TokenFile string        `mapstructure:"token_file"`
+}
+
+// validate rejects a listener reachable from other hosts without a token,
+// since the change feed carries every key and value in plain text.
+func (c replicationConfig) validate() error {
+	if c.Listen == "" || c.TokenFile != "" || strings.HasPrefix(c.Listen, "unix://") {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(c.Listen)
+	if err != nil {
+		return fmt.Errorf("invalid listen address %q: %w", c.Listen, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("listen address %q is not a loopback address, set a token file to listen on it", c.Listen)
+	}
+	return nil
+}
+
+// token loads the shared secret of the primary and its followers, nil when
+// none is configured.
+func (c replicationConfig) token() ([]byte, error) {
+	if c.TokenFile == "" {
+		return nil, nil
+	}
+	content, err := os.ReadFile(c.TokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("read badger replication token: %w", err)
+	}
+	token := bytes.TrimRight(content, "\r\n")
+	if len(token) == 0 {
+		return nil, fmt.Errorf("badger replication token file %s is empty", c.TokenFile)
+	}
+	return token, nil
+}
+
+func (c replicationConfig) heartbeat() time.Duration {
+	if c.Heartbeat <= 0 {
+		return time.Second
+	}
+	return c.Heartbeat
+}
+
+// ReplicationStatus describes how far a follower is behind its primary.
+type ReplicationStatus struct {
+	Primary   string
+	Connected bool
+	// AppliedVersion is the version on the primary of the last change
+	// applied.
+	AppliedVersion uint64
+	// Lag is the time since the primary wrote the latest heartbeat the
+	// follower has applied, it grows while the follower is disconnected.
+	Lag time.Duration
+}
+
+type replicationState struct {
+	// token authenticates followers, it is set before the store serves or
+	// follows and not changed after
+	token []byte
+
+	mu        sync.Mutex
+	connected bool
+	applied   uint64
+	heartbeat time.Time
+}
+
+// replicationFrame is the unit of the replication stream, a msgpack value per
+// batch of changes in version order.
+type replicationFrame struct {
+	Changes []replicatedChange `msgpack:"changes"`
+}
+
+type replicatedChange struct {
+	Key       []byte `msgpack:"key"`
+	Value     []byte `msgpack:"value,omitempty"`
+	Version   uint64 `msgpack:"version"`
+	ExpiresAt uint64 `msgpack:"expires_at,omitempty"`
+	UserMeta  byte   `msgpack:"user_meta,omitempty"`
+	Deleted   bool   `msgpack:"deleted,omitempty"`
+}
+
+var (
+	replicationHeartbeatKey = reservedKey("replication", "heartbeat")
+	replicationCursorKey    = reservedKey("replication", "cursor")
+	watchReadyPrefix        = reservedKey("watch-ready", "")
+	watchCursorPrefix       = reservedKey("watch", "")
+)
+
+// skipReplicated drops the keys that only make sense on the store that wrote
+// them: watch markers and cursors, whose versions are local, and the cursor
+// of a follower.
+func skipReplicated(key []byte) bool {
+	return bytes.HasPrefix(key, watchReadyPrefix) ||
+		bytes.HasPrefix(key, watchCursorPrefix) ||
+		bytes.Equal(key, replicationCursorKey)
+}
+
+// ReplicationHandler serves the change feed of the store to followers. A
+// follower asks for the changes after the version it applied last and then
+// receives every later change as it is committed, reserved keys included, in
+// plain text even when the store is encrypted. With replication.token_file
+// set, followers have to present the token as a bearer token. The handler is
+// served on replication.listen when it is set, it can be mounted on another
+// server as well, which then has to stop serving before the store is closed
+// and, without a token, must only be reachable by followers.
+func (db *BadgerApp) ReplicationHandler() http.Handler {
+	return http.HandlerFunc(db.serveReplication)
+}
+
+func (db *BadgerApp) serveReplication(w http.ResponseWriter, r *http.Request) {
+	if token := db.replication.token; token != nil {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), token) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid replication token", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var since uint64
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("invalid since %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stop := context.AfterFunc(db.background, cancel)
+	defer stop()
+
+	batches := make(chan []Change, 16)
+	watchErr := make(chan error, 1)
+	feed := &watcher{
+		db:     db,
+		cursor: since,
+		replay: true,
+		skip:   skipReplicated,
+		handler: func(ctx context.Context, changes []Change) error {
+			timer := time.NewTimer(replicationStallTimeout)
+			defer timer.Stop()
+			select {
+			case batches <- changes:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-timer.C:
+				return errFollowerTooSlow
+			}
+		},
+	}
+	go func() {
+		watchErr <- feed.run(ctx, [][]byte{nil})
+	}()
+
+	logger := db.Logger.With(zap.String("follower", r.RemoteAddr), zap.Uint64("since", since))
+	logger.Info("badger follower connected")
+
+	w.Header().Set("Content-Type", "application/msgpack")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	enc := msgpack.NewEncoder(w)
+
+	ticker := time.NewTicker(db.config.Replication.heartbeat())
+	defer ticker.Stop()
+	err := rc.Flush()
+	for err == nil {
+		select {
+		case changes := <-batches:
+			var frame replicationFrame
+			if frame.Changes, err = db.replicatedChanges(changes); err != nil {
+				break
+			}
+			if err = enc.Encode(&frame); err != nil {
+				break
+			}
+			err = rc.Flush()
+		case <-ticker.C:
+			// the heartbeat travels through the change feed, so a follower
+			// applying it has every change committed before it
+			err = db.DB.Update(func(txn *badger.Txn) error {
+				return txn.Set(replicationHeartbeatKey, binary.BigEndian.AppendUint64(nil, uint64(time.Now().UnixNano())))
+			})
+		case err = <-watchErr:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	cancel()
+	if errors.Is(err, context.Canceled) {
+		logger.Info("badger follower disconnected")
+	} else {
+		logger.Warn("badger replication stream failed", zap.Error(err))
+	}
+}
+
+// replicatedChanges tells the deleted keys apart from the ones written empty,
+// which the change feed does not. A key written again since is sent as
+// deleted, its later version follows anyway.
+func (db *BadgerApp) replicatedChanges(changes []Change) ([]replicatedChange, error) {
+	out := make([]replicatedChange, 0, len(changes))
+	err := db.DB.View(func(txn *badger.Txn) error {
+		for _, c := range changes {
+			rc := replicatedChange{
+				Key:       c.Key,
+				Value:     c.Value,
+				Version:   c.Version,
+				ExpiresAt: c.ExpiresAt,
+				UserMeta:  c.UserMeta,
+			}
+			if len(c.Value) == 0 {
+				item, err := txn.Get(c.Key)
+				if errors.Is(err, badger.ErrKeyNotFound) {
+					rc.Deleted = true
+				} else if err != nil {
+					return err
+				} else {
+					rc.Deleted = item.Version() != c.Version
+				}
+			}
+			out = append(out, rc)
+		}
+		return nil
+	})
+	return out, err
+}
+
+// ReplicationStatus returns the state of the replication from the primary
+// configured in replication.primary.
+func (db *BadgerApp) ReplicationStatus() ReplicationStatus {
+	s := &db.replication
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := ReplicationStatus{
+		Primary:        db.config.Replication.Primary,
+		Connected:      s.connected,
+		AppliedVersion: s.applied,
+	}
+	if !s.heartbeat.IsZero() {
+		status.Lag = max(time.Since(s.heartbeat), 0)
+	}
+	return status
+}
+
+// loadReplicationCursor resumes the replication where the follower stopped.
+// Changes are applied along with the primary version of the last one.
+func (db *BadgerApp) loadReplicationCursor() error {
+	cursor, err := db.replicationCursor()
+	if err != nil {
+		return err
+	}
+	s := &db.replication
+	s.mu.Lock()
+	s.applied, s.heartbeat = cursor, time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// runReplication follows the primary until ctx is done, reconnecting after
+// failures.
+func (db *BadgerApp) runReplication(ctx context.Context) {
+	s := &db.replication
+	for {
+		err := db.follow(ctx)
+		s.mu.Lock()
+		s.connected = false
+		s.mu.Unlock()
+		if ctx.Err() != nil {
+			return
+		}
+		db.Logger.Warn("badger replication interrupted",
+			zap.String("primary", db.config.Replication.Primary), zap.Error(err))
+		if sleep(ctx, replicationRetryInterval) != nil {
+			return
+		}
+	}
+}
+
+func (db *BadgerApp) follow(ctx context.Context) error {
+	client, u, err := replicationClient(db.config.Replication.Primary)
+	if err != nil {
+		return err
+	}
+	s := &db.replication
+	s.mu.Lock()
+	since := s.applied
+	s.mu.Unlock()
+	q := u.Query()
+	q.Set("since", strconv.FormatUint(since, 10))
+	u.RawQuery = q.Encode()
+
+	// a primary gone silent is noticed by its missing heartbeats
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	timeout := 3 * db.config.Replication.heartbeat()
+	watchdog := time.AfterFunc(timeout, func() {
+		cancel(fmt.Errorf("no heartbeat from the primary for %s", timeout))
+	})
+	defer watchdog.Stop()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if token := db.replication.token; token != nil {
+		req.Header.Set("Authorization", "Bearer "+string(token))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("primary answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	s.mu.Lock()
+	s.connected = true
+	s.mu.Unlock()
+	db.Logger.Info("badger replication connected",
+		zap.String("primary", db.config.Replication.Primary), zap.Uint64("since", since))
+
+	dec := msgpack.NewDecoder(resp.Body)
+	for {
+		var frame replicationFrame
+		if err := dec.Decode(&frame); err != nil {
+			if cause := context.Cause(ctx); cause != nil {
+				return cause
+			}
+			return err
+		}
+		watchdog.Reset(timeout)
+		if err := db.applyReplicated(frame.Changes); err != nil {
+			return err
+		}
+	}
+}
+
+// applyReplicated writes changes together with the cursor, splitting them
+// over several transactions when they do not fit in one.
+func (db *BadgerApp) applyReplicated(changes []replicatedChange) error {
+	for start := 0; start < len(changes); {
+		end := len(changes)
+		for {
+			err := db.DB.Update(func(txn *badger.Txn) error {
+				for _, c := range changes[start:end] {
+					var err error
+					switch {
+					case bytes.Equal(c.Key, replicationHeartbeatKey):
+					case c.Deleted:
+						err = txn.Delete(c.Key)
+					default:
+						entry := badger.NewEntry(c.Key, c.Value).WithMeta(c.UserMeta)
+						entry.ExpiresAt = c.ExpiresAt
+						err = txn.SetEntry(entry)
+					}
+					if err != nil {
+						return err
+					}
+				}
+				if cursor := appliedVersion(changes, end); cursor > 0 {
+					return txn.Set(replicationCursorKey, binary.BigEndian.AppendUint64(nil, cursor))
+				}
+				return nil
+			})
+			if errors.Is(err, badger.ErrTxnTooBig) && end-start > 1 {
+				end = start + (end-start)/2
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			break
+		}
+		start = end
+	}
+
+	s := &db.replication
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range changes {
+		if bytes.Equal(c.Key, replicationHeartbeatKey) && len(c.Value) == 8 {
+			s.heartbeat = time.Unix(0, int64(binary.BigEndian.Uint64(c.Value)))
+		}
+		s.applied = max(s.applied, c.Version)
+	}
+	return nil
+}
+
+// appliedVersion returns the last version whose changes are all among
+// changes[:end], which are sorted by version. A transaction too big for the
+// follower is split, the cursor must not move past the version of its keys
+// still to apply: the primary resumes after the cursor.
+func appliedVersion(changes []replicatedChange, end int) uint64 {
+	version := changes[end-1].Version
+	if end < len(changes) && changes[end].Version == version {
+		version--
+	}
+	return version
+}
+
+func (db *BadgerApp) replicationCursor() (uint64, error) {
+	var cursor uint64
+	err := db.DB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(replicationCursorKey)
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			if len(val) != 8 {
+				return errors.New("invalid replication cursor")
+			}
+			cursor = binary.BigEndian.Uint64(val)
+			return nil
+		})
+	})
+	return cursor, err
+}
+
+// replicationClient returns the client and the URL reaching primary, given
+// as an http(s) URL or as unix:///path/to/socket.
+func replicationClient(primary string) (*http.Client, *url.URL, error) {
+	u, err := url.Parse(primary)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return &http.Client{}, u, nil
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &http.Client{Transport: transport}, &url.URL{Scheme: "http", Host: "unix", Path: "/"}, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported replication primary %q, expected an http(s) or unix URL", primary)
+	}
+}
+
+// listenReplication listens on addr, a TCP address or unix:///path/to/socket.
+// A socket left behind by a previous process is replaced, one another process
+// still listens on is not. The socket is only accessible to its owner.
+func listenReplication(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix://")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("replication socket %s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// serveReplicationOn serves ReplicationHandler on l until ctx is done, then
+// waits for the streams to end.
+func (db *BadgerApp) serveReplicationOn(l net.Listener) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		var mu sync.Mutex
+		var streams sync.WaitGroup
+		closed := false
+		handler := db.ReplicationHandler()
+		srv := &http.Server{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				if closed {
+					mu.Unlock()
+					http.Error(w, "badger store is closing", http.StatusServiceUnavailable)
+					return
+				}
+				streams.Add(1)
+				mu.Unlock()
+				defer streams.Done()
+				handler.ServeHTTP(w, r)
+			}),
+			BaseContext:       func(net.Listener) context.Context { return ctx },
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			<-ctx.Done()
+			_ = srv.Close()
+		}()
+		if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+			db.Logger.Error("badger replication server failed", zap.Error(err))
+		}
+		mu.Lock()
+		closed = true
+		mu.Unlock()
+		streams.Wait()
+	}
+}
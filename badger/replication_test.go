@@ -0,0 +1,278 @@
+package badger
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// waitFor polls cond until it holds or the timeout passes.
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+func newReplicaApp(t *testing.T, dir string, replication replicationConfig) *BadgerApp {
+	return newDiskApp(t, dir, func(app *BadgerApp) {
+		app.config.Replication = replication
+	})
+}
+
+// TestReplicationPrimaryProcess runs the primary of TestReplication in a
+// process of its own. It reads commands from stdin, one per line, and answers
+// each with ok once applied.
+func TestReplicationPrimaryProcess(t *testing.T) {
+	dir := os.Getenv("BADGER_REPLICATION_PRIMARY")
+	if dir == "" {
+		t.Skip("started by TestReplication")
+	}
+	primary := newReplicaApp(t, filepath.Join(dir, "primary"), replicationConfig{
+		Listen:    "unix://" + filepath.Join(dir, "primary.sock"),
+		Heartbeat: 50 * time.Millisecond,
+	})
+	defer primary.Close(t.Context())
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var err error
+		switch args := strings.Fields(scanner.Text()); args[0] {
+		case "set":
+			value := ""
+			if len(args) > 2 {
+				value = args[2]
+			}
+			err = set(primary, args[1], value)
+		case "delete":
+			err = primary.Update(func(txn *badger.Txn) error {
+				return txn.Delete([]byte(args[1]))
+			})
+		case "bulk":
+			var n int
+			if n, err = strconv.Atoi(args[2]); err == nil {
+				err = setMany(primary, args[1], n)
+			}
+		case "sequence":
+			var seq *Sequence
+			if seq, err = primary.Sequence(args[1]); err == nil {
+				_, err = seq.Next()
+			}
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Println("ok")
+	}
+}
+
+func countKeys(app *BadgerApp, prefix string) int {
+	n := 0
+	_ = app.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = []byte(prefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			n++
+		}
+		return nil
+	})
+	return n
+}
+
+func TestReplication(t *testing.T) {
+	Convey("Replication from a primary process over a unix socket", t, func() {
+		dir := t.TempDir()
+		socket := "unix://" + filepath.Join(dir, "primary.sock")
+
+		cmd := exec.Command(os.Args[0], "-test.run=^TestReplicationPrimaryProcess$")
+		cmd.Env = append(os.Environ(), "BADGER_REPLICATION_PRIMARY="+dir)
+		cmd.Stderr = os.Stderr
+		stdin, err := cmd.StdinPipe()
+		So(err, ShouldBeNil)
+		stdout, err := cmd.StdoutPipe()
+		So(err, ShouldBeNil)
+		So(cmd.Start(), ShouldBeNil)
+		defer func() {
+			stdin.Close()
+			So(cmd.Wait(), ShouldBeNil)
+		}()
+		replies := bufio.NewScanner(stdout)
+		primary := func(command string) {
+			_, err := fmt.Fprintln(stdin, command)
+			So(err, ShouldBeNil)
+			So(replies.Scan(), ShouldBeTrue)
+			So(replies.Text(), ShouldEqual, "ok")
+		}
+
+		primary("set a 1")
+		primary("set b 2")
+		primary("set empty")
+		primary("delete b")
+		primary("sequence ids")
+		// more keys in one transaction than the primary sends at once
+		primary("bulk bootstrap/ 1500")
+
+		// the socket of a running primary is not taken over
+		_, err = listenReplication(socket)
+		So(err, ShouldNotBeNil)
+
+		followerDir := filepath.Join(dir, "follower")
+		follower := newReplicaApp(t, followerDir, replicationConfig{Primary: socket, Heartbeat: 50 * time.Millisecond})
+
+		// the initial sync replays the state of the primary
+		So(waitFor(5*time.Second, func() bool {
+			value, err := get(follower, "a")
+			return err == nil && value == "1"
+		}), ShouldBeTrue)
+		_, err = get(follower, "b")
+		So(err, ShouldEqual, badger.ErrKeyNotFound)
+		value, err := get(follower, "empty")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, "")
+		_, err = get(follower, string(reservedKey("sequence", "ids")))
+		So(err, ShouldBeNil)
+		So(waitFor(5*time.Second, func() bool { return countKeys(follower, "bootstrap/") == 1500 }), ShouldBeTrue)
+
+		// later changes are streamed
+		primary("set c 3")
+		primary("delete a")
+		So(waitFor(5*time.Second, func() bool {
+			_, err := get(follower, "a")
+			return errors.Is(err, badger.ErrKeyNotFound)
+		}), ShouldBeTrue)
+		value, err = get(follower, "c")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, "3")
+
+		So(waitFor(5*time.Second, func() bool {
+			status := follower.ReplicationStatus()
+			return status.Connected && status.Lag < time.Second
+		}), ShouldBeTrue)
+		So(follower.ReplicationStatus().AppliedVersion, ShouldBeGreaterThan, 0)
+
+		// a restarted follower resumes from its cursor
+		follower.Close(t.Context())
+		for i := range 100 {
+			primary(fmt.Sprintf("set later/%02d value", i))
+		}
+		primary("bulk resumed/ 1500")
+		follower = newReplicaApp(t, followerDir, replicationConfig{Primary: socket, Heartbeat: 50 * time.Millisecond})
+		defer follower.Close(t.Context())
+		So(follower.ReplicationStatus().AppliedVersion, ShouldBeGreaterThan, 0)
+		So(waitFor(5*time.Second, func() bool {
+			_, err := get(follower, "later/99")
+			return err == nil
+		}), ShouldBeTrue)
+		So(waitFor(5*time.Second, func() bool { return countKeys(follower, "resumed/") == 1500 }), ShouldBeTrue)
+	})
+
+	Convey("Replication handler over HTTP with a token", t, func() {
+		dir := t.TempDir()
+		tokenFile := filepath.Join(dir, "token")
+		So(os.WriteFile(tokenFile, []byte("secret\n"), 0o600), ShouldBeNil)
+		primary := newReplicaApp(t, filepath.Join(dir, "primary"), replicationConfig{TokenFile: tokenFile})
+		defer primary.Close(t.Context())
+		srv := httptest.NewServer(primary.ReplicationHandler())
+		defer srv.Close()
+
+		resp, err := http.Get(srv.URL)
+		So(err, ShouldBeNil)
+		resp.Body.Close()
+		So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"?since=invalid", nil)
+		So(err, ShouldBeNil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err = http.DefaultClient.Do(req)
+		So(err, ShouldBeNil)
+		resp.Body.Close()
+		So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+
+		So(set(primary, "key", "value"), ShouldBeNil)
+		follower := newReplicaApp(t, filepath.Join(dir, "follower"), replicationConfig{
+			Primary:   srv.URL,
+			Heartbeat: 50 * time.Millisecond,
+			TokenFile: tokenFile,
+		})
+		So(waitFor(5*time.Second, func() bool {
+			value, err := get(follower, "key")
+			return err == nil && value == "value"
+		}), ShouldBeTrue)
+		// the follower has to stop before the server it streams from
+		follower.Close(t.Context())
+	})
+
+	Convey("A transaction too big for the follower", t, func() {
+		follower := newDiskApp(t, t.TempDir())
+		defer follower.Close(t.Context())
+
+		value := bytes.Repeat([]byte("v"), 100)
+		var changes []replicatedChange
+		for i := range 30000 {
+			changes = append(changes, replicatedChange{Key: fmt.Appendf(nil, "big/%05d", i), Value: value, Version: 7})
+		}
+		changes = append(changes, replicatedChange{Key: []byte("next"), Value: value, Version: 8})
+		So(follower.DB.Update(func(txn *badger.Txn) error {
+			for _, c := range changes {
+				if err := txn.Set(c.Key, c.Value); err != nil {
+					return err
+				}
+			}
+			return nil
+		}), ShouldEqual, badger.ErrTxnTooBig)
+
+		So(follower.applyReplicated(changes), ShouldBeNil)
+		So(countKeys(follower, "big/"), ShouldEqual, 30000)
+		cursor, err := follower.replicationCursor()
+		So(err, ShouldBeNil)
+		So(cursor, ShouldEqual, 8)
+
+		// a part of the transaction does not move the cursor past it
+		So(appliedVersion(changes, 15000), ShouldEqual, 6)
+		So(appliedVersion(changes, 30000), ShouldEqual, 7)
+		So(appliedVersion(changes, len(changes)), ShouldEqual, 8)
+	})
+
+	Convey("Replication listeners", t, func() {
+		So(replicationConfig{Listen: "127.0.0.1:0"}.validate(), ShouldBeNil)
+		So(replicationConfig{Listen: "localhost:7000"}.validate(), ShouldBeNil)
+		So(replicationConfig{Listen: "[::1]:7000"}.validate(), ShouldBeNil)
+		So(replicationConfig{Listen: ":7000"}.validate(), ShouldNotBeNil)
+		So(replicationConfig{Listen: "10.0.0.1:7000"}.validate(), ShouldNotBeNil)
+		So(replicationConfig{Listen: ":7000", TokenFile: "token"}.validate(), ShouldBeNil)
+
+		// a socket left behind is replaced and only accessible to its owner
+		path := filepath.Join(t.TempDir(), "stale.sock")
+		l, err := net.Listen("unix", path)
+		So(err, ShouldBeNil)
+		l.(*net.UnixListener).SetUnlinkOnClose(false)
+		l.Close()
+		l, err = listenReplication("unix://" + path)
+		So(err, ShouldBeNil)
+		defer l.Close()
+		info, err := os.Stat(path)
+		So(err, ShouldBeNil)
+		So(info.Mode().Perm(), ShouldEqual, os.FileMode(0o600))
+	})
+}
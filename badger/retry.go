@@ -0,0 +1,80 @@
+package badger
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy describes how updates failing with badger.ErrConflict are
+// retried. It is also the retry section of the configuration.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times an update runs at most, values
+	// below 2 disable retries.
+	MaxAttempts int `mapstructure:"max_attempts"`
+	// InitialBackoff is the wait before the first retry, it doubles for every
+	// further retry up to MaxBackoff.
+	InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+	MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+	// Jitter is the fraction of the backoff randomly taken off each wait, so
+	// that writers conflicting together do not retry together.
+	Jitter float64 `mapstructure:"jitter"`
+}
+
+func (p RetryPolicy) validate() error {
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("retry jitter must be in [0, 1], got %v", p.Jitter)
+	}
+	if p.MaxBackoff > 0 && p.InitialBackoff > p.MaxBackoff {
+		return fmt.Errorf("retry initial backoff %s exceeds max backoff %s", p.InitialBackoff, p.MaxBackoff)
+	}
+	return nil
+}
+
+// backoff returns the wait before the given retry, counted from 1.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < retry && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 {
+		d = min(d, p.MaxBackoff)
+	}
+	return d - time.Duration(p.Jitter*rand.Float64()*float64(d))
+}
+
+// WithRetryPolicy overrides the retry policy of a single update.
+func WithRetryPolicy(p RetryPolicy) TraceOption {
+	return func(o *traceOptions) {
+		o.retry = p
+	}
+}
+
+// RetryPolicy returns the configured retry policy, a starting point for per
+// call overrides.
+func (db *BadgerApp) RetryPolicy() RetryPolicy {
+	return db.config.Retry
+}
+
+// UpdateWithRetry is like UpdateContext but retries conflicts following the
+// configured retry policy. Each retry is counted and recorded as a span event,
+// and waiting stops as soon as ctx is done.
+func (db *BadgerApp) UpdateWithRetry(ctx context.Context, fn func(txn *Txn) error, opts ...TraceOption) error {
+	return db.UpdateContext(ctx, fn, append([]TraceOption{WithRetryPolicy(db.config.Retry)}, opts...)...)
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
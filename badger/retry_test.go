@@ -0,0 +1,72 @@
+package badger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRetry(t *testing.T) {
+	app := newMemoryApp(t)
+	app.config.Retry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	// conflicting reads key and has it overwritten concurrently before
+	// committing, so every attempt conflicts.
+	conflicting := func(attempts *int) func(txn *Txn) error {
+		return func(txn *Txn) error {
+			*attempts++
+			if _, err := txn.Get([]byte("retry")); err != nil && err != badger.ErrKeyNotFound {
+				return err
+			}
+			if err := set(app, "retry", "concurrent"); err != nil {
+				return err
+			}
+			return txn.Set([]byte("retry"), []byte("value"))
+		}
+	}
+
+	Convey("RetryPolicy backoff", t, func() {
+		p := RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 40 * time.Millisecond}
+		So(p.backoff(1), ShouldEqual, 10*time.Millisecond)
+		So(p.backoff(2), ShouldEqual, 20*time.Millisecond)
+		So(p.backoff(3), ShouldEqual, 40*time.Millisecond)
+		So(p.backoff(10), ShouldEqual, 40*time.Millisecond)
+
+		p.Jitter = 0.5
+		for range 100 {
+			So(p.backoff(1), ShouldBeBetweenOrEqual, 5*time.Millisecond, 10*time.Millisecond)
+		}
+
+		So(RetryPolicy{Jitter: 2}.validate(), ShouldNotBeNil)
+	})
+
+	Convey("UpdateWithRetry", t, func() {
+		before := app.stats.retries.Load()
+		attempts := 0
+		err := app.UpdateWithRetry(t.Context(), conflicting(&attempts))
+		So(err, ShouldEqual, badger.ErrConflict)
+		So(attempts, ShouldEqual, 3)
+		So(app.stats.retries.Load()-before, ShouldEqual, 2)
+
+		// per call overrides
+		p := app.RetryPolicy()
+		p.MaxAttempts = 5
+		attempts = 0
+		err = app.UpdateWithRetry(t.Context(), conflicting(&attempts), WithRetryPolicy(p))
+		So(err, ShouldEqual, badger.ErrConflict)
+		So(attempts, ShouldEqual, 5)
+
+		// cancellation interrupts the backoff
+		p.InitialBackoff = time.Hour
+		ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+		defer cancel()
+		attempts = 0
+		err = app.UpdateWithRetry(ctx, conflicting(&attempts), WithRetryPolicy(p))
+		So(err, ShouldWrap, context.DeadlineExceeded)
+		So(err, ShouldWrap, badger.ErrConflict)
+		So(attempts, ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,166 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/dgraph-io/badger/v4/pb"
+	"github.com/dgraph-io/ristretto/v2/z"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// KV is a key-value pair read by Scan.
+type KV struct {
+	Key       []byte
+	Value     []byte
+	Version   uint64
+	ExpiresAt uint64
+	UserMeta  byte
+}
+
+// ScanProgress is reported periodically while a scan runs.
+type ScanProgress struct {
+	Keys    uint64
+	Bytes   uint64
+	Elapsed time.Duration
+}
+
+type scanConfig struct {
+	Goroutines int `mapstructure:"goroutines"`
+}
+
+type scanOptions struct {
+	goroutines       int
+	filter           func(item *badger.Item) bool
+	progress         func(ScanProgress)
+	progressInterval time.Duration
+	reserved         bool
+}
+
+type ScanOption func(*scanOptions)
+
+// WithScanGoroutines sets the number of goroutines reading key ranges,
+// overriding the configured default.
+func WithScanGoroutines(n int) ScanOption {
+	return func(o *scanOptions) {
+		o.goroutines = n
+	}
+}
+
+// WithScanFilter skips the keys for which filter returns false before their
+// value is read. filter is called concurrently.
+func WithScanFilter(filter func(item *badger.Item) bool) ScanOption {
+	return func(o *scanOptions) {
+		o.filter = filter
+	}
+}
+
+// WithScanProgress calls fn with the progress of the scan at most every
+// interval, and once more when it finishes successfully.
+func WithScanProgress(interval time.Duration, fn func(ScanProgress)) ScanOption {
+	return func(o *scanOptions) {
+		o.progressInterval = interval
+		o.progress = fn
+	}
+}
+
+// withReservedKeys also scans the keys the app keeps for itself.
+func withReservedKeys() ScanOption {
+	return func(o *scanOptions) {
+		o.reserved = true
+	}
+}
+
+// Scan calls fn with the latest version of every live key under prefix,
+// reading key ranges in parallel. Keys arrive in no particular order but fn
+// is never called concurrently. The slices of kv are only valid during the
+// call. Scan stops as soon as ctx is done or fn fails.
+func (db *BadgerApp) Scan(ctx context.Context, prefix []byte, fn func(kv KV) error, opts ...ScanOption) error {
+	o := scanOptions{goroutines: db.config.Scan.Goroutines}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.goroutines <= 0 {
+		o.goroutines = 8
+	}
+
+	ctx, span := db.startSpan(ctx, "scan", &traceOptions{spanName: "badger.Scan", keyPrefix: prefix})
+	defer span.End()
+	span.SetAttributes(attribute.Int("db.badger.goroutines", o.goroutines))
+
+	start, last := time.Now(), time.Now()
+	var progress ScanProgress
+	report := func() {
+		progress.Elapsed = time.Since(start)
+		o.progress(progress)
+	}
+
+	stream := db.DB.NewStream()
+	stream.Prefix = prefix
+	stream.NumGo = o.goroutines
+	stream.LogPrefix = "badger.Scan"
+	stream.ChooseKey = func(item *badger.Item) bool {
+		return (o.reserved || !isReservedKey(item.Key())) && (o.filter == nil || o.filter(item))
+	}
+	stream.KeyToList = func(key []byte, itr *badger.Iterator) (*pb.KVList, error) {
+		item := itr.Item()
+		if item.IsDeletedOrExpired() || !bytes.Equal(key, item.Key()) {
+			return nil, nil
+		}
+		a := itr.Alloc
+		kv := &pb.KV{
+			Key:       a.Copy(key),
+			Version:   item.Version(),
+			ExpiresAt: item.ExpiresAt(),
+			UserMeta:  []byte{item.UserMeta()},
+		}
+		err := item.Value(func(val []byte) error {
+			kv.Value = a.Copy(val)
+			return nil
+		})
+		return &pb.KVList{Kv: []*pb.KV{kv}}, err
+	}
+	// badger reports the cancellation of its producers rather than the error
+	// that caused it, so errors of fn are kept aside.
+	var fnErr error
+	stream.Send = func(buf *z.Buffer) error {
+		list, err := badger.BufferToKVList(buf)
+		if err != nil {
+			return err
+		}
+		for _, kv := range list.Kv {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(KV{
+				Key:       kv.Key,
+				Value:     kv.Value,
+				Version:   kv.Version,
+				ExpiresAt: kv.ExpiresAt,
+				UserMeta:  kv.UserMeta[0],
+			}); err != nil {
+				fnErr = err
+				return err
+			}
+			progress.Keys++
+			progress.Bytes += uint64(len(kv.Key) + len(kv.Value))
+		}
+		if o.progress != nil && time.Since(last) >= o.progressInterval {
+			last = time.Now()
+			report()
+		}
+		return nil
+	}
+
+	err := stream.Orchestrate(ctx)
+	if fnErr != nil {
+		err = fnErr
+	}
+	if err == nil && o.progress != nil {
+		report()
+	}
+	endSpan(span, &Txn{bytesRead: int64(progress.Bytes), items: int64(progress.Keys)}, err)
+	return err
+}
@@ -0,0 +1,76 @@
+package badger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScan(t *testing.T) {
+	app := newMemoryApp(t)
+	ctx := t.Context()
+
+	const keys = 5000
+	w := app.NewBatchWriter()
+	for i := range keys {
+		if err := w.Set(ctx, fmt.Appendf(nil, "scan/%04d", i), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Set(ctx, []byte("other"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.DB.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte("scan/0000"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("Scan", t, func() {
+		seen := map[string]string{}
+		var progress []ScanProgress
+		err := app.Scan(ctx, []byte("scan/"), func(kv KV) error {
+			seen[string(kv.Key)] = string(kv.Value)
+			return nil
+		}, WithScanGoroutines(4), WithScanProgress(0, func(p ScanProgress) {
+			progress = append(progress, p)
+		}))
+		So(err, ShouldBeNil)
+		So(seen, ShouldHaveLength, keys-1)
+		So(seen, ShouldNotContainKey, "scan/0000")
+		So(seen["scan/4999"], ShouldEqual, "value")
+		So(progress[len(progress)-1].Keys, ShouldEqual, keys-1)
+
+		count := 0
+		err = app.Scan(ctx, nil, func(kv KV) error {
+			count++
+			return nil
+		}, WithScanFilter(func(item *badger.Item) bool {
+			return strings.HasSuffix(string(item.Key()), "0")
+		}))
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, keys/10-1)
+
+		failure := errors.New("stop")
+		err = app.Scan(ctx, nil, func(kv KV) error { return failure })
+		So(err, ShouldEqual, failure)
+
+		cancelled, cancel := context.WithCancel(ctx)
+		start := time.Now()
+		err = app.Scan(cancelled, nil, func(kv KV) error {
+			cancel()
+			return nil
+		})
+		So(err, ShouldWrap, context.Canceled)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+	})
+}
@@ -0,0 +1,122 @@
+package badger
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	// idWidth is the number of digits of the largest uint64.
+	idWidth = 20
+	// defaultSequenceBandwidth is used when no bandwidth is configured.
+	defaultSequenceBandwidth = 1000
+)
+
+type sequenceConfig struct {
+	Bandwidth uint64 `mapstructure:"bandwidth"`
+}
+
+type sequenceOptions struct {
+	bandwidth uint64
+}
+
+type SequenceOption func(*sequenceOptions)
+
+// WithLeaseBandwidth sets how many ids a sequence leases at once, overriding
+// the configured default. Leased ids that are not handed out before Close
+// are lost, so larger leases mean fewer writes but larger gaps.
+func WithLeaseBandwidth(n uint64) SequenceOption {
+	return func(o *sequenceOptions) {
+		o.bandwidth = n
+	}
+}
+
+// Sequence hands out increasing ids persisted across restarts. Ids are unique
+// but not contiguous. It is safe for concurrent use.
+type Sequence struct {
+	name string
+	seq  *badger.Sequence
+}
+
+// Sequence returns the sequence called name, creating it on first use. Later
+// calls return the same sequence and ignore opts. Sequences are released in
+// Close.
+func (db *BadgerApp) Sequence(name string, opts ...SequenceOption) (*Sequence, error) {
+	if !validName(name) {
+		return nil, fmt.Errorf("invalid sequence name %q", name)
+	}
+
+	db.sequencesMu.Lock()
+	defer db.sequencesMu.Unlock()
+
+	if s, ok := db.sequences[name]; ok {
+		return s, nil
+	}
+
+	o := sequenceOptions{bandwidth: db.config.Sequence.Bandwidth}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.bandwidth == 0 {
+		o.bandwidth = defaultSequenceBandwidth
+	}
+
+	seq, err := db.DB.GetSequence(reservedKey("sequence", name), o.bandwidth)
+	if err != nil {
+		return nil, err
+	}
+	s := &Sequence{name: name, seq: seq}
+	if db.sequences == nil {
+		db.sequences = map[string]*Sequence{}
+	}
+	db.sequences[name] = s
+	return s, nil
+}
+
+// Next returns the next id.
+func (s *Sequence) Next() (uint64, error) {
+	return s.seq.Next()
+}
+
+// NextString returns the next id formatted by FormatID.
+func (s *Sequence) NextString() (string, error) {
+	id, err := s.seq.Next()
+	if err != nil {
+		return "", err
+	}
+	return FormatID(id), nil
+}
+
+// FormatID renders id zero-padded to a fixed width, so that ids sort as
+// strings in the same order as numbers and can be used inside keys.
+func FormatID(id uint64) string {
+	s := strconv.FormatUint(id, 10)
+	return strings.Repeat("0", idWidth-len(s)) + s
+}
+
+// ParseID parses an id formatted by FormatID.
+func ParseID(s string) (uint64, error) {
+	if len(s) != idWidth {
+		return 0, fmt.Errorf("invalid id %q", s)
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// releaseSequences gives back the leased ids that were not handed out.
+func (db *BadgerApp) releaseSequences() error {
+	db.sequencesMu.Lock()
+	defer db.sequencesMu.Unlock()
+
+	var errs []error
+	for name, s := range db.sequences {
+		if err := s.seq.Release(); err != nil {
+			errs = append(errs, fmt.Errorf("release sequence %s: %w", name, err))
+		}
+	}
+	db.sequences = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,70 @@
+package badger
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSequence(t *testing.T) {
+	Convey("Sequence", t, func() {
+		app := newDiskApp(t, filepath.Join(t.TempDir(), "db"))
+
+		seq, err := app.Sequence("assets", WithLeaseBandwidth(10))
+		So(err, ShouldBeNil)
+		same, err := app.Sequence("assets")
+		So(err, ShouldBeNil)
+		So(same, ShouldEqual, seq)
+
+		var mu sync.Mutex
+		seen := map[uint64]bool{}
+		var wg sync.WaitGroup
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 100 {
+					id, err := seq.Next()
+					if err != nil {
+						panic(err)
+					}
+					mu.Lock()
+					seen[id] = true
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		So(seen, ShouldHaveLength, 800)
+
+		last, err := seq.Next()
+		So(err, ShouldBeNil)
+		app.Close(t.Context())
+
+		// released ids are handed out again after a restart
+		app = newDiskApp(t, app.config.Path)
+		defer app.Close(t.Context())
+		seq, err = app.Sequence("assets")
+		So(err, ShouldBeNil)
+		next, err := seq.Next()
+		So(err, ShouldBeNil)
+		So(next, ShouldEqual, last+1)
+
+		_, err = app.Sequence("")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("FormatID", t, func() {
+		So(FormatID(42), ShouldEqual, "00000000000000000042")
+		So(FormatID(9) < FormatID(10), ShouldBeTrue)
+		So(FormatID(^uint64(0)), ShouldHaveLength, idWidth)
+
+		id, err := ParseID(FormatID(123456))
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, 123456)
+		_, err = ParseID("42")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,266 @@
+package badger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// namespaceSeparator ends the namespace part of every key written by a Store,
+// so that namespaces sharing a prefix never overlap.
+const namespaceSeparator = 0x00
+
+// Transactor runs badger transactions. It is implemented by both *badger.DB
+// and *BadgerApp, passing the app lets helpers be created before Initialize.
+type Transactor interface {
+	View(fn func(txn *badger.Txn) error) error
+	Update(fn func(txn *badger.Txn) error) error
+}
+
+var (
+	_ Transactor = (*badger.DB)(nil)
+	_ Transactor = (*BadgerApp)(nil)
+)
+
+// Entry is a key-value pair read from a Store.
+type Entry[K, V any] struct {
+	Key       K
+	Value     V
+	ExpiresAt uint64
+}
+
+// Store is a typed view over the keys of one namespace in a badger DB.
+// Several stores with distinct namespaces can share the same DB.
+type Store[K, V any] struct {
+	db        Transactor
+	namespace []byte
+	keys      KeyEncoder[K]
+	codec     Codec[V]
+	indexes   []storeIndex[V]
+}
+
+// NewStore creates a store that keeps its keys under namespace in db.
+func NewStore[K, V any](db Transactor, namespace string, keys KeyEncoder[K], codec Codec[V]) *Store[K, V] {
+	if namespace == "" || bytes.IndexByte([]byte(namespace), namespaceSeparator) >= 0 {
+		panic(fmt.Errorf("invalid store namespace %q", namespace))
+	}
+	return &Store[K, V]{
+		db:        db,
+		namespace: append([]byte(namespace), namespaceSeparator),
+		keys:      keys,
+		codec:     codec,
+	}
+}
+
+// Get returns the value of key, or badger.ErrKeyNotFound.
+func (s *Store[K, V]) Get(key K) (value V, err error) {
+	err = s.db.View(func(txn *badger.Txn) error {
+		value, err = s.In(txn).Get(key)
+		return err
+	})
+	return value, err
+}
+
+// Has reports whether key exists.
+func (s *Store[K, V]) Has(key K) (found bool, err error) {
+	err = s.db.View(func(txn *badger.Txn) error {
+		found, err = s.In(txn).Has(key)
+		return err
+	})
+	return found, err
+}
+
+// Put stores value under key without expiry.
+func (s *Store[K, V]) Put(key K, value V) error {
+	return s.PutWithTTL(key, value, 0)
+}
+
+// PutWithTTL stores value under key, expiring after ttl when it is positive.
+func (s *Store[K, V]) PutWithTTL(key K, value V, ttl time.Duration) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return s.In(txn).PutWithTTL(key, value, ttl)
+	})
+}
+
+// Delete removes key. Deleting a missing key is not an error, nor is it
+// rejected by the quota.
+func (s *Store[K, V]) Delete(key K) error {
+	update := s.db.Update
+	if app, ok := s.db.(*BadgerApp); ok {
+		update = app.update
+	}
+	return update(func(txn *badger.Txn) error {
+		return s.In(txn).Delete(key)
+	})
+}
+
+// Range calls fn for every entry whose encoded key starts with prefix, in key
+// order. Returning an error from fn stops the iteration.
+func (s *Store[K, V]) Range(prefix []byte, fn func(Entry[K, V]) error) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		return s.In(txn).Range(prefix, fn)
+	})
+}
+
+// List returns every entry whose encoded key starts with prefix.
+func (s *Store[K, V]) List(prefix []byte) ([]Entry[K, V], error) {
+	var entries []Entry[K, V]
+	err := s.Range(prefix, func(e Entry[K, V]) error {
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
+
+// In binds the store to an existing transaction, so that several stores can
+// be read and written atomically.
+func (s *Store[K, V]) In(txn *badger.Txn) *StoreTxn[K, V] {
+	return &StoreTxn[K, V]{s, txn}
+}
+
+func (s *Store[K, V]) encodeKey(key K) ([]byte, error) {
+	k, err := s.keys.EncodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return append(bytes.Clone(s.namespace), k...), nil
+}
+
+func (s *Store[K, V]) decodeItem(item *badger.Item) (Entry[K, V], error) {
+	var e Entry[K, V]
+	key, err := s.keys.DecodeKey(item.Key()[len(s.namespace):])
+	if err != nil {
+		return e, err
+	}
+	err = item.Value(func(val []byte) error {
+		e.Value, err = s.codec.Unmarshal(val)
+		return err
+	})
+	e.Key, e.ExpiresAt = key, item.ExpiresAt()
+	return e, err
+}
+
+// StoreTxn is a Store bound to a transaction.
+type StoreTxn[K, V any] struct {
+	store *Store[K, V]
+	txn   *badger.Txn
+}
+
+func (t *StoreTxn[K, V]) Get(key K) (value V, err error) {
+	item, err := t.item(key)
+	if err != nil {
+		return value, err
+	}
+	err = item.Value(func(val []byte) error {
+		value, err = t.store.codec.Unmarshal(val)
+		return err
+	})
+	return value, err
+}
+
+func (t *StoreTxn[K, V]) Has(key K) (bool, error) {
+	_, err := t.item(key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (t *StoreTxn[K, V]) Put(key K, value V) error {
+	return t.PutWithTTL(key, value, 0)
+}
+
+func (t *StoreTxn[K, V]) PutWithTTL(key K, value V, ttl time.Duration) error {
+	k, err := t.store.encodeKey(key)
+	if err != nil {
+		return err
+	}
+	v, err := t.store.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	old, err := t.indexed(key)
+	if err != nil {
+		return err
+	}
+
+	entry := badger.NewEntry(k, v)
+	if ttl > 0 {
+		entry = entry.WithTTL(ttl)
+	}
+	if err := t.txn.SetEntry(entry); err != nil {
+		return err
+	}
+	for _, idx := range t.store.indexes {
+		if err := idx.put(t.txn, k[len(t.store.namespace):], old, value, entry.ExpiresAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *StoreTxn[K, V]) Delete(key K) error {
+	k, err := t.store.encodeKey(key)
+	if err != nil {
+		return err
+	}
+	old, err := t.indexed(key)
+	if err != nil {
+		return err
+	}
+
+	if err := t.txn.Delete(k); err != nil {
+		return err
+	}
+	if old == nil {
+		return nil
+	}
+	for _, idx := range t.store.indexes {
+		if err := idx.remove(t.txn, k[len(t.store.namespace):], *old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexed returns the current value of key when the store has indexes whose
+// entries it has to update, nil otherwise.
+func (t *StoreTxn[K, V]) indexed(key K) (*V, error) {
+	if len(t.store.indexes) == 0 {
+		return nil, nil
+	}
+	old, err := t.Get(key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, nil
+	}
+	return &old, err
+}
+
+func (t *StoreTxn[K, V]) Range(prefix []byte, fn func(Entry[K, V]) error) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = append(bytes.Clone(t.store.namespace), prefix...)
+	it := t.txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		e, err := t.store.decodeItem(it.Item())
+		if err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *StoreTxn[K, V]) item(key K) (*badger.Item, error) {
+	k, err := t.store.encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return t.txn.Get(k)
+}
@@ -0,0 +1,61 @@
+package badger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testRecord struct {
+	Name  string
+	Ports []int
+}
+
+func TestStore(t *testing.T) {
+	app := newMemoryApp(t)
+
+	Convey("Store", t, func() {
+		records := NewStore(app.DB, "record", StringKey{}, JSONCodec[testRecord]{})
+		shadow := NewStore(app.DB, "recor", StringKey{}, MsgpackCodec[testRecord]{})
+
+		So(records.Put("host/a", testRecord{Name: "a", Ports: []int{80}}), ShouldBeNil)
+		So(records.Put("host/b", testRecord{Name: "b"}), ShouldBeNil)
+		So(records.Put("other", testRecord{Name: "other"}), ShouldBeNil)
+		So(shadow.Put("dhost/c", testRecord{Name: "c"}), ShouldBeNil)
+
+		record, err := records.Get("host/a")
+		So(err, ShouldBeNil)
+		So(record.Ports, ShouldResemble, []int{80})
+
+		_, err = records.Get("missing")
+		So(err, ShouldEqual, badger.ErrKeyNotFound)
+
+		entries, err := records.List([]byte("host/"))
+		So(err, ShouldBeNil)
+		So(entries, ShouldHaveLength, 2)
+		So(entries[1].Key, ShouldEqual, "host/b")
+
+		entries, err = shadow.List(nil)
+		So(err, ShouldBeNil)
+		So(entries, ShouldHaveLength, 1)
+
+		So(records.Delete("host/a"), ShouldBeNil)
+		found, err := records.Has("host/a")
+		So(err, ShouldBeNil)
+		So(found, ShouldBeFalse)
+	})
+
+	Convey("Store TTL", t, func() {
+		counters := NewStore(app.DB, "counter", Uint64Key{}, GobCodec[int]{})
+		So(counters.PutWithTTL(1, 42, time.Second), ShouldBeNil)
+
+		entries, err := counters.List(nil)
+		So(err, ShouldBeNil)
+		So(entries, ShouldHaveLength, 1)
+		So(entries[0].Key, ShouldEqual, 1)
+		So(entries[0].Value, ShouldEqual, 42)
+		So(entries[0].ExpiresAt, ShouldBeGreaterThan, 0)
+	})
+}
@@ -0,0 +1,210 @@
+package badger
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/dgraph-io/badger/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Txn wraps a badger transaction and counts the bytes read and written
+// through it, so traced helpers can report them.
+type Txn struct {
+	*badger.Txn
+	bytesRead    int64
+	bytesWritten int64
+	items        int64
+}
+
+func (t *Txn) Get(key []byte) (*badger.Item, error) {
+	item, err := t.Txn.Get(key)
+	if err == nil {
+		t.bytesRead += int64(len(key)) + item.ValueSize()
+	}
+	return item, err
+}
+
+func (t *Txn) Set(key, val []byte) error {
+	return t.SetEntry(badger.NewEntry(key, val))
+}
+
+func (t *Txn) SetEntry(e *badger.Entry) error {
+	err := t.Txn.SetEntry(e)
+	if err == nil {
+		t.bytesWritten += int64(len(e.Key) + len(e.Value))
+	}
+	return err
+}
+
+func (t *Txn) Delete(key []byte) error {
+	err := t.Txn.Delete(key)
+	if err == nil {
+		t.bytesWritten += int64(len(key))
+	}
+	return err
+}
+
+type traceOptions struct {
+	spanName    string
+	keyPrefix   []byte
+	retry       RetryPolicy
+	quotaExempt bool
+}
+
+type TraceOption func(*traceOptions)
+
+// WithSpanName overrides the default span name such as "badger.View".
+func WithSpanName(name string) TraceOption {
+	return func(o *traceOptions) {
+		o.spanName = name
+	}
+}
+
+// WithKeyPrefix records the key prefix the transaction works on.
+func WithKeyPrefix(prefix []byte) TraceOption {
+	return func(o *traceOptions) {
+		o.keyPrefix = prefix
+	}
+}
+
+// WithMaxRetries retries an update up to n times when it fails with
+// badger.ErrConflict, without waiting in between.
+func WithMaxRetries(n int) TraceOption {
+	return func(o *traceOptions) {
+		o.retry = RetryPolicy{MaxAttempts: n + 1}
+	}
+}
+
+// ViewContext runs fn in a read-only transaction traced by a span.
+func (db *BadgerApp) ViewContext(ctx context.Context, fn func(txn *Txn) error, opts ...TraceOption) error {
+	o := newTraceOptions("badger.View", opts)
+	_, span := db.startSpan(ctx, "view", o)
+	defer span.End()
+
+	txn := &Txn{}
+	err := db.DB.View(func(t *badger.Txn) error {
+		txn.Txn = t
+		return fn(txn)
+	})
+	endSpan(span, txn, err)
+	return err
+}
+
+// UpdateContext runs fn in a read-write transaction traced by a span. fn may
+// run several times when WithMaxRetries or WithRetryPolicy is set.
+func (db *BadgerApp) UpdateContext(ctx context.Context, fn func(txn *Txn) error, opts ...TraceOption) error {
+	o := newTraceOptions("badger.Update", opts)
+	ctx, span := db.startSpan(ctx, "update", o)
+	defer span.End()
+
+	update := db.Update
+	if o.quotaExempt {
+		update = db.update
+	}
+
+	var txn *Txn
+	var err error
+	retries := 0
+	for {
+		txn = &Txn{}
+		err = update(func(t *badger.Txn) error {
+			txn.Txn = t
+			return fn(txn)
+		})
+		if !errors.Is(err, badger.ErrConflict) || retries+1 >= o.retry.MaxAttempts {
+			break
+		}
+		if ctxErr := sleep(ctx, o.retry.backoff(retries+1)); ctxErr != nil {
+			err = fmt.Errorf("%w after %w", ctxErr, err)
+			break
+		}
+		retries++
+		db.stats.retries.Add(1)
+		span.AddEvent("db.badger.retry", trace.WithAttributes(attribute.Int("db.badger.attempt", retries+1)))
+	}
+	span.SetAttributes(attribute.Int("db.badger.retries", retries))
+	endSpan(span, txn, err)
+	return err
+}
+
+// IterateContext calls fn for every item matched by opts in a read-only
+// transaction traced by a span. It stops early when ctx is done.
+func (db *BadgerApp) IterateContext(ctx context.Context, opts badger.IteratorOptions, fn func(item *badger.Item) error, traceOpts ...TraceOption) error {
+	o := newTraceOptions("badger.Iterate", append([]TraceOption{WithKeyPrefix(opts.Prefix)}, traceOpts...))
+	ctx, span := db.startSpan(ctx, "iterate", o)
+	defer span.End()
+
+	txn := &Txn{}
+	err := db.DB.View(func(t *badger.Txn) error {
+		txn.Txn = t
+		it := t.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			item := it.Item()
+			txn.items++
+			txn.bytesRead += int64(len(item.Key()))
+			if opts.PrefetchValues {
+				txn.bytesRead += item.ValueSize()
+			}
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	endSpan(span, txn, err)
+	return err
+}
+
+func newTraceOptions(spanName string, opts []TraceOption) *traceOptions {
+	o := &traceOptions{spanName: spanName}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (db *BadgerApp) startSpan(ctx context.Context, operation string, o *traceOptions) (context.Context, trace.Span) {
+	set := db.metricAttributes()
+	attrs := append(set.ToSlice(), attribute.String("db.operation", operation))
+	if len(o.keyPrefix) > 0 {
+		attrs = append(attrs, attribute.String("db.badger.key_prefix", printableKey(o.keyPrefix)))
+	}
+	return otel.Tracer(ScopeName).Start(ctx, o.spanName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+}
+
+func endSpan(span trace.Span, txn *Txn, err error) {
+	span.SetAttributes(
+		attribute.Int64("db.badger.bytes_read", txn.bytesRead),
+		attribute.Int64("db.badger.bytes_written", txn.bytesWritten),
+	)
+	if txn.items > 0 {
+		span.SetAttributes(attribute.Int64("db.badger.items", txn.items))
+	}
+	if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// printableKey renders a key as text when it is valid UTF-8 and as hex
+// otherwise.
+func printableKey(key []byte) string {
+	if utf8.Valid(key) {
+		return string(key)
+	}
+	return hex.EncodeToString(key)
+}
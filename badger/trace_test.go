@@ -0,0 +1,66 @@
+package badger
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	app := newMemoryApp(t)
+
+	Convey("UpdateContext retries conflicts", t, func() {
+		attempts := 0
+		err := app.UpdateContext(t.Context(), func(txn *Txn) error {
+			attempts++
+			if _, err := txn.Get([]byte("asset/1")); err != nil && err != badger.ErrKeyNotFound {
+				return err
+			}
+			if attempts == 1 {
+				So(set(app, "asset/1", "concurrent"), ShouldBeNil)
+			}
+			return txn.Set([]byte("asset/1"), []byte("value"))
+		}, WithKeyPrefix([]byte("asset/")), WithMaxRetries(3))
+		So(err, ShouldBeNil)
+		So(attempts, ShouldEqual, 2)
+
+		spans := recorder.Ended()
+		span := spans[len(spans)-1]
+		So(span.Name(), ShouldEqual, "badger.Update")
+		So(spanAttribute(span, "db.badger.key_prefix").AsString(), ShouldEqual, "asset/")
+		So(spanAttribute(span, "db.badger.retries").AsInt64(), ShouldEqual, 1)
+		So(spanAttribute(span, "db.badger.bytes_written").AsInt64(), ShouldEqual, len("asset/1value"))
+	})
+
+	Convey("IterateContext", t, func() {
+		count := 0
+		err := app.IterateContext(t.Context(), badger.IteratorOptions{Prefix: []byte("asset/")}, func(item *badger.Item) error {
+			count++
+			return nil
+		})
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 1)
+
+		spans := recorder.Ended()
+		span := spans[len(spans)-1]
+		So(span.Name(), ShouldEqual, "badger.Iterate")
+		So(spanAttribute(span, "db.badger.items").AsInt64(), ShouldEqual, 1)
+	})
+}
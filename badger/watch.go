@@ -0,0 +1,374 @@
+package badger
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/dgraph-io/badger/v4/pb"
+	"go.uber.org/zap"
+)
+
+// watchBatchSize is the number of changes handed to the handler at once while
+// catching up.
+const watchBatchSize = 1000
+
+// badgerInternalPrefix starts the keys badger keeps for itself.
+var badgerInternalPrefix = []byte("!badger!")
+
+// Change is a key written to the store. Deleted keys are delivered with an
+// empty value, badger does not tell them apart from empty writes.
+type Change struct {
+	Key       []byte
+	Value     []byte
+	Version   uint64
+	ExpiresAt uint64
+	UserMeta  byte
+}
+
+// Watch calls handler with the changes of every key under one of prefixes
+// until ctx is done or handler fails. An empty prefix matches every key.
+//
+// When name is not empty the version of the last change handled successfully
+// is persisted under it, and a later Watch with the same name first replays
+// the current state of every key changed since then. A named watch started
+// for the first time replays the whole keyspace. Changes are delivered at
+// least once.
+//
+// handler runs on badger's publisher and should return quickly, slow handlers
+// eventually stall writers. Names cannot contain 0x00.
+func (db *BadgerApp) Watch(ctx context.Context, name string, prefixes [][]byte, handler func(context.Context, []Change) error) error {
+	if name != "" && !validName(name) {
+		return fmt.Errorf("invalid watch name %q", name)
+	}
+	return db.watch(ctx, name, prefixes, handler)
+}
+
+// watch is Watch without checking name, the app names its own watches so
+// that their cursors never collide with the ones of Watch.
+func (db *BadgerApp) watch(ctx context.Context, name string, prefixes [][]byte, handler func(context.Context, []Change) error) error {
+	if len(prefixes) == 0 {
+		return errors.New("watch needs at least one prefix")
+	}
+
+	w := &watcher{db: db, name: name, handler: handler, replay: name != "", skip: isReservedKey}
+	if name != "" {
+		cursor, err := w.loadCursor()
+		if err != nil {
+			return err
+		}
+		w.cursor = cursor
+	}
+	return w.run(ctx, prefixes)
+}
+
+// run subscribes to the changes under prefixes, replays the ones missed since
+// the cursor when asked to and delivers them until ctx is done or the handler
+// fails.
+func (w *watcher) run(ctx context.Context, prefixes [][]byte) error {
+	db, name := w.db, w.name
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	marker := reservedKey("watch-ready", fmt.Sprintf("%s-%d", name, time.Now().UnixNano()))
+	w.marker, w.ready = marker, make(chan struct{})
+
+	matches := []pb.Match{{Prefix: marker}}
+	for _, prefix := range prefixes {
+		matches = append(matches, pb.Match{Prefix: prefix})
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- db.DB.Subscribe(ctx, w.receive, matches)
+	}()
+
+	// The subscriber is registered once it sees the marker, anything
+	// committed after that point reaches it and everything before is
+	// covered by the catch-up snapshot. Subscribe gives no other signal, so
+	// the marker is written until it shows up.
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for ready := false; !ready; {
+		if err := db.DB.Update(func(txn *badger.Txn) error {
+			return txn.Set(marker, nil)
+		}); err != nil {
+			return err
+		}
+		select {
+		case <-w.ready:
+			ready = true
+		case <-ticker.C:
+		case err := <-done:
+			return err
+		}
+	}
+	if err := db.DB.Update(func(txn *badger.Txn) error {
+		return txn.Delete(marker)
+	}); err != nil {
+		return err
+	}
+
+	if w.replay {
+		if err := w.catchUp(ctx, prefixes); err != nil {
+			return err
+		}
+	}
+	if err := w.flushPending(ctx); err != nil {
+		return err
+	}
+
+	return <-done
+}
+
+// WatchChan is like Watch but delivers changes on a channel. A change counts
+// as handled once it has been received from the channel. The error channel
+// receives the reason the watch stopped, both channels are then closed.
+func (db *BadgerApp) WatchChan(ctx context.Context, name string, prefixes [][]byte) (<-chan Change, <-chan error) {
+	changes := make(chan Change)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(changes)
+		errs <- db.Watch(ctx, name, prefixes, func(ctx context.Context, batch []Change) error {
+			for _, change := range batch {
+				select {
+				case changes <- change:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+	}()
+
+	return changes, errs
+}
+
+type watcher struct {
+	db      *BadgerApp
+	name    string
+	handler func(context.Context, []Change) error
+	// replay catches up from the cursor before following the subscription
+	replay bool
+	// skip drops changes of keys the handler is not interested in
+	skip func(key []byte) bool
+
+	marker    []byte
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	mu       sync.Mutex
+	ctx      context.Context
+	caughtUp bool
+	pending  [][]Change
+	cursor   uint64
+}
+
+func (w *watcher) cursorKey() []byte {
+	return reservedKey("watch", w.name)
+}
+
+func (w *watcher) loadCursor() (uint64, error) {
+	var cursor uint64
+	err := w.db.DB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(w.cursorKey())
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			if len(val) != 8 {
+				return fmt.Errorf("invalid watch cursor of %s", w.name)
+			}
+			cursor = binary.BigEndian.Uint64(val)
+			return nil
+		})
+	})
+	return cursor, err
+}
+
+func (w *watcher) saveCursor(cursor uint64) error {
+	w.cursor = cursor
+	if w.name == "" {
+		return nil
+	}
+	return w.db.DB.Update(func(txn *badger.Txn) error {
+		return txn.Set(w.cursorKey(), binary.BigEndian.AppendUint64(nil, cursor))
+	})
+}
+
+// receive is the badger subscription callback.
+func (w *watcher) receive(list *pb.KVList) error {
+	changes := make([]Change, 0, len(list.Kv))
+	for _, kv := range list.Kv {
+		if bytes.Equal(kv.Key, w.marker) {
+			w.readyOnce.Do(func() { close(w.ready) })
+			continue
+		}
+		// badger publishes its transaction markers to the subscriptions
+		// matching every key
+		if bytes.HasPrefix(kv.Key, badgerInternalPrefix) || w.skip(kv.Key) {
+			continue
+		}
+		changes = append(changes, kvToChange(kv))
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	w.mu.Lock()
+	if !w.caughtUp {
+		w.pending = append(w.pending, changes)
+		w.mu.Unlock()
+		return nil
+	}
+	ctx := w.ctx
+	w.mu.Unlock()
+
+	return w.deliver(ctx, changes)
+}
+
+// deliver hands the changes newer than the cursor to the handler and advances
+// the cursor.
+func (w *watcher) deliver(ctx context.Context, changes []Change) error {
+	changes = slices.DeleteFunc(changes, func(c Change) bool {
+		return c.Version <= w.cursor
+	})
+	if len(changes) == 0 {
+		return nil
+	}
+	if err := w.handler(ctx, changes); err != nil {
+		return err
+	}
+	return w.saveCursor(changes[len(changes)-1].Version)
+}
+
+// catchUp replays the latest state of every key changed after the cursor, in
+// version order, from a snapshot taken after the subscription was registered.
+func (w *watcher) catchUp(ctx context.Context, prefixes [][]byte) error {
+	txn := w.db.DB.NewTransaction(false)
+	defer txn.Discard()
+
+	var changes []Change
+	for _, prefix := range prefixes {
+		opts := badger.DefaultIteratorOptions
+		opts.AllVersions = true
+		opts.Prefix = prefix
+		opts.SinceTs = w.cursor
+
+		it := txn.NewIterator(opts)
+		var last []byte
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if bytes.Equal(item.Key(), last) || w.skip(item.Key()) {
+				continue
+			}
+			last = item.KeyCopy(last[:0])
+
+			change := Change{
+				Key:       item.KeyCopy(nil),
+				Version:   item.Version(),
+				ExpiresAt: item.ExpiresAt(),
+				UserMeta:  item.UserMeta(),
+			}
+			if !item.IsDeletedOrExpired() {
+				value, err := item.ValueCopy(nil)
+				if err != nil {
+					it.Close()
+					return err
+				}
+				change.Value = value
+			}
+			changes = append(changes, change)
+		}
+		it.Close()
+	}
+
+	// overlapping prefixes yield the same key twice
+	slices.SortFunc(changes, func(a, b Change) int {
+		if c := bytes.Compare(a.Key, b.Key); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.Version, a.Version)
+	})
+	changes = slices.CompactFunc(changes, func(a, b Change) bool {
+		return bytes.Equal(a.Key, b.Key)
+	})
+	slices.SortStableFunc(changes, func(a, b Change) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+
+	for batch := range versionBatches(changes, watchBatchSize) {
+		if err := w.deliver(ctx, batch); err != nil {
+			return err
+		}
+	}
+
+	if readTs := txn.ReadTs(); readTs > w.cursor {
+		if err := w.saveCursor(readTs); err != nil {
+			return err
+		}
+	}
+	w.db.Logger.Debug("watch caught up", zap.String("name", w.name), zap.Int("changes", len(changes)))
+	return nil
+}
+
+// versionBatches splits changes sorted by version into batches of about size
+// changes. The keys of one transaction share its commit version and the
+// cursor only tells versions apart, so a version is never split, a batch
+// grows past size instead.
+func versionBatches(changes []Change, size int) iter.Seq[[]Change] {
+	return func(yield func([]Change) bool) {
+		for start := 0; start < len(changes); {
+			end := min(start+size, len(changes))
+			for end < len(changes) && changes[end].Version == changes[end-1].Version {
+				end++
+			}
+			if !yield(changes[start:end]) {
+				return
+			}
+			start = end
+		}
+	}
+}
+
+// flushPending delivers the changes received while catching up and switches
+// to delivering straight from the subscription.
+func (w *watcher) flushPending(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, changes := range w.pending {
+		if err := w.deliver(ctx, changes); err != nil {
+			return err
+		}
+	}
+	w.pending = nil
+	w.ctx = ctx
+	w.caughtUp = true
+	return nil
+}
+
+func kvToChange(kv *pb.KV) Change {
+	c := Change{
+		Key:       kv.Key,
+		Value:     kv.Value,
+		Version:   kv.Version,
+		ExpiresAt: kv.ExpiresAt,
+	}
+	if len(kv.Meta) > 0 {
+		c.UserMeta = kv.Meta[0]
+	}
+	return c
+}
@@ -0,0 +1,197 @@
+package badger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWatch(t *testing.T) {
+	app := newMemoryApp(t)
+
+	receive := func(ch <-chan Change) string {
+		select {
+		case change := <-ch:
+			return string(change.Key) + "=" + string(change.Value)
+		case <-time.After(5 * time.Second):
+			return "timeout"
+		}
+	}
+
+	Convey("Watch", t, func() {
+		So(set(app, "asset/1", "a"), ShouldBeNil)
+		So(set(app, "other/1", "x"), ShouldBeNil)
+
+		ctx, cancel := context.WithCancel(t.Context())
+		changes, errs := app.WatchChan(ctx, "assets", [][]byte{[]byte("asset/")})
+
+		So(receive(changes), ShouldEqual, "asset/1=a")
+		So(set(app, "asset/2", "b"), ShouldBeNil)
+		So(receive(changes), ShouldEqual, "asset/2=b")
+
+		cancel()
+		So(<-errs, ShouldEqual, context.Canceled)
+
+		// changes made while nobody watches are replayed on resume
+		So(set(app, "asset/3", "c"), ShouldBeNil)
+		So(set(app, "other/2", "y"), ShouldBeNil)
+
+		ctx, cancel = context.WithCancel(t.Context())
+		defer cancel()
+		changes, _ = app.WatchChan(ctx, "assets", [][]byte{[]byte("asset/")})
+		So(receive(changes), ShouldEqual, "asset/3=c")
+		So(set(app, "asset/4", "d"), ShouldBeNil)
+		So(receive(changes), ShouldEqual, "asset/4=d")
+	})
+}
+
+// collector gathers the changes handed to a Watch handler.
+type collector struct {
+	mu      sync.Mutex
+	changes map[string]Change
+}
+
+func (c *collector) handle(_ context.Context, changes []Change) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.changes == nil {
+		c.changes = map[string]Change{}
+	}
+	for _, change := range changes {
+		c.changes[string(change.Key)] = change
+	}
+	return nil
+}
+
+func (c *collector) get(key string) (Change, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	change, ok := c.changes[key]
+	return change, ok
+}
+
+func (c *collector) keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Sorted(maps.Keys(c.changes))
+}
+
+func (c *collector) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.changes)
+}
+
+// watch runs Watch with the handler of c until the test ends.
+func (c *collector) watch(t *testing.T, app *BadgerApp, name string, prefixes ...string) context.CancelFunc {
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	var list [][]byte
+	for _, prefix := range prefixes {
+		list = append(list, []byte(prefix))
+	}
+	go func() {
+		defer close(done)
+		_ = app.Watch(ctx, name, list, c.handle)
+	}()
+	stop := func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+func setMany(app *BadgerApp, prefix string, n int) error {
+	return app.Update(func(txn *badger.Txn) error {
+		for i := range n {
+			if err := txn.Set(fmt.Appendf(nil, "%s%04d", prefix, i), []byte("v")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func TestWatchHandler(t *testing.T) {
+	Convey("A transaction larger than a catch-up batch is replayed whole", t, func() {
+		app := newMemoryApp(t)
+		So(setMany(app, "bulk/", 1500), ShouldBeNil)
+
+		var first collector
+		stop := first.watch(t, app, "bulk", "bulk/")
+		So(waitFor(5*time.Second, func() bool { return first.len() == 1500 }), ShouldBeTrue)
+		stop()
+
+		// resuming past a version replays the next one whole too
+		So(setMany(app, "bulk/more/", 1500), ShouldBeNil)
+		var resumed collector
+		resumed.watch(t, app, "bulk", "bulk/")
+		So(waitFor(5*time.Second, func() bool { return resumed.len() == 1500 }), ShouldBeTrue)
+		_, ok := resumed.get("bulk/0001")
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Watch", t, func() {
+		app := newMemoryApp(t)
+		So(set(app, "a/1", "one"), ShouldBeNil)
+		So(set(app, "b/1", "two"), ShouldBeNil)
+		So(set(app, "c/1", "three"), ShouldBeNil)
+
+		Convey("follows several prefixes", func() {
+			var c collector
+			c.watch(t, app, "several", "a/", "b/")
+			So(waitFor(5*time.Second, func() bool { return c.len() == 2 }), ShouldBeTrue)
+			So(set(app, "b/2", "four"), ShouldBeNil)
+			So(set(app, "c/2", "five"), ShouldBeNil)
+			So(waitFor(5*time.Second, func() bool { return c.len() == 3 }), ShouldBeTrue)
+			change, _ := c.get("b/2")
+			So(string(change.Value), ShouldEqual, "four")
+			_, ok := c.get("c/1")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("follows every key with the empty prefix, but the reserved ones", func() {
+			var c collector
+			c.watch(t, app, "all", "")
+			So(waitFor(5*time.Second, func() bool { return c.len() == 3 }), ShouldBeTrue)
+			So(set(app, "d/1", "four"), ShouldBeNil)
+			So(waitFor(5*time.Second, func() bool { return c.len() == 4 }), ShouldBeTrue)
+			So(c.keys(), ShouldResemble, []string{"a/1", "b/1", "c/1", "d/1"})
+		})
+
+		Convey("delivers deleted keys with an empty value", func() {
+			var c collector
+			c.watch(t, app, "deletes", "a/")
+			So(waitFor(5*time.Second, func() bool { return c.len() == 1 }), ShouldBeTrue)
+			So(app.Update(func(txn *badger.Txn) error {
+				return txn.Delete([]byte("a/1"))
+			}), ShouldBeNil)
+			So(waitFor(5*time.Second, func() bool {
+				change, _ := c.get("a/1")
+				return len(change.Value) == 0
+			}), ShouldBeTrue)
+		})
+
+		Convey("stops with the error of the handler", func() {
+			failure := errors.New("failed")
+			err := app.Watch(t.Context(), "failing", [][]byte{[]byte("a/")}, func(context.Context, []Change) error {
+				return failure
+			})
+			So(err, ShouldEqual, failure)
+		})
+
+		Convey("needs a prefix and a valid name", func() {
+			So(app.Watch(t.Context(), "none", nil, nil), ShouldNotBeNil)
+			So(app.Watch(t.Context(), "a\x00b", [][]byte{nil}, nil), ShouldNotBeNil)
+		})
+	})
+}